@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"golang.org/x/crypto/bcrypt"
+	)
+
+// Who may use the web UI, and what they may do.  With an Auth section in
+// the config, every page needs a signed in user, and changes need a role:
+//
+//   viewer    see everything
+//   operator  also disable services, edit filters, silences and incidents,
+//             and clear events
+//   admin     also reload the config
+//
+// Users come from one of two places.  In "users" mode (the default) they
+// sign in with a name and password from UsersFile, which has a line per
+// user of name:role:bcrypt-hash (see -hash-password).  In "proxy" mode a
+// reverse proxy in front of the hub has already signed them in and says
+// who they are in UserHeader, with their role in RoleHeader or else
+// DefaultRole.  Only requests from TrustedProxies are believed.
+//
+// Without an Auth section anyone who can reach the hub is an admin, as
+// before, though changes still have to be POSTs, and not from a page on
+// another site.  Either way each change is written to the audit log with
+// the user who made it.
+//
+// Reloading the config rereads the Auth section and UsersFile.  Sessions
+// carry on with the users' roles as they now are, and end for users who
+// are no longer in the file.
+
+type authDef struct {
+	// "users" or "proxy"
+	Mode string `yaml:"Mode"`
+	UsersFile string `yaml:"UsersFile"`
+	// defaults to X-Forwarded-User
+	UserHeader string `yaml:"UserHeader"`
+	RoleHeader string `yaml:"RoleHeader"`
+	// defaults to viewer
+	DefaultRole string `yaml:"DefaultRole"`
+	// addresses or CIDR ranges, eg 127.0.0.1 or 10.0.0.0/8
+	TrustedProxies []string `yaml:"TrustedProxies"`
+	// file the audit log is appended to; it goes to the main log if unset
+	AuditLog string `yaml:"AuditLog"`
+	// minutes a sign in lasts, 720 if unset
+	SessionMinutes int `yaml:"SessionMinutes"`
+}
+
+type Role int
+
+const (
+	ROLE_NONE Role = iota
+	ROLE_VIEWER
+	ROLE_OPERATOR
+	ROLE_ADMIN
+	)
+
+var roleNames = map[Role] string{ROLE_VIEWER: "viewer", ROLE_OPERATOR: "operator", ROLE_ADMIN: "admin"}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (Role, error) {
+	for role, roleName := range(roleNames) {
+		if strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return ROLE_NONE, fmt.Errorf("unknown role \"%s\"; expected viewer, operator or admin", name)
+}
+
+type User struct {
+	Name string
+	Role Role
+}
+
+// whether the user may do what needs role
+func (u *User) Can(role Role) bool {
+	return u != nil && u.Role >= role
+}
+
+func (u *User) CanOperate() bool {
+	return u.Can(ROLE_OPERATOR)
+}
+
+type localUser struct {
+	role Role
+	hash []byte
+}
+
+type session struct {
+	user *User
+	expires time.Time
+}
+
+const (
+	DEFAULT_SESSION_MINUTES = 720
+	SESSION_COOKIE = "gospoke_session"
+	)
+
+// the settings from the Auth section, which are replaced as a whole when
+// the config is reloaded
+type authSettings struct {
+	// "" when there is no Auth section
+	mode string
+	users map[string] *localUser
+	userHeader string
+	roleHeader string
+	defaultRole Role
+	trusted []*net.IPNet
+	sessionLength time.Duration
+	audit *log.Logger
+	// the file audit writes to, or ""
+	auditLog string
+	// auditLog once opened by openAuditLog
+	auditFile *os.File
+}
+
+type Authenticator struct {
+	settings atomic.Pointer[authSettings]
+
+	mutex sync.Mutex
+	sessions map[string] *session
+}
+
+// the authenticator configured by conf.Auth, reading the users file
+func newAuthenticator(conf *optionsDef) (*Authenticator, error) {
+	settings, err := newAuthSettings(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.openAuditLog(nil); err != nil {
+		return nil, err
+	}
+	a := &Authenticator{sessions: make(map[string] *session)}
+	a.settings.Store(settings)
+	return a, nil
+}
+
+// the settings configured by conf.Auth.  They audit to the main log until
+// openAuditLog is called.
+func newAuthSettings(conf *optionsDef) (*authSettings, error) {
+	a := &authSettings{audit: log.New(os.Stderr, "audit: ", log.LstdFlags)}
+
+	def := conf.Auth
+	if def == nil {
+		return a, nil
+	}
+
+	line := lineOfText(conf.data, "Auth")
+	fail := func(msg string) error {
+		return configErrors{&configError{conf.filename, line, "Auth: "+msg}}
+	}
+
+	a.mode = def.Mode
+	if a.mode == "" {
+		a.mode = "users"
+	}
+
+	a.sessionLength = time.Duration(def.SessionMinutes) * time.Minute
+	if def.SessionMinutes == 0 {
+		a.sessionLength = DEFAULT_SESSION_MINUTES * time.Minute
+	} else if def.SessionMinutes < 0 {
+		return nil, fail("SessionMinutes must not be negative")
+	}
+
+	switch a.mode {
+	case "users":
+		if def.UsersFile == "" {
+			return nil, fail("UsersFile is required")
+		}
+		users, err := readUsersFile(conf.resolvePath(def.UsersFile))
+		if err != nil {
+			return nil, fail(err.Error())
+		}
+		a.users = users
+
+	case "proxy":
+		a.userHeader = def.UserHeader
+		if a.userHeader == "" {
+			a.userHeader = "X-Forwarded-User"
+		}
+		a.roleHeader = def.RoleHeader
+
+		a.defaultRole = ROLE_VIEWER
+		if def.DefaultRole != "" {
+			role, err := parseRole(def.DefaultRole)
+			if err != nil {
+				return nil, fail("DefaultRole: "+err.Error())
+			}
+			a.defaultRole = role
+		}
+
+		if len(def.TrustedProxies) == 0 {
+			return nil, fail("TrustedProxies is required in proxy mode")
+		}
+		for _, proxy := range(def.TrustedProxies) {
+			network, err := parseTrustedProxy(proxy)
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+			a.trusted = append(a.trusted, network)
+		}
+
+	default:
+		return nil, fail("Mode must be users or proxy")
+	}
+
+	if def.AuditLog != "" {
+		a.auditLog = conf.resolvePath(def.AuditLog)
+	}
+
+	return a, nil
+}
+
+// opens the AuditLog file, or shares it with previous if that has the
+// same one open
+func (a *authSettings) openAuditLog(previous *authSettings) error {
+	if a.auditLog == "" {
+		return nil
+	}
+	if previous != nil && previous.auditFile != nil && previous.auditLog == a.auditLog {
+		a.audit = previous.audit
+		a.auditFile = previous.auditFile
+		return nil
+	}
+
+	f, err := os.OpenFile(a.auditLog, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	a.audit = log.New(f, "", log.LstdFlags)
+	a.auditFile = f
+	return nil
+}
+
+// switches to settings read from a reloaded config, closing an audit log
+// they no longer write to
+func (a *Authenticator) reload(settings *authSettings) {
+	old := a.settings.Swap(settings)
+	if old.auditFile != nil && old.auditFile != settings.auditFile {
+		old.auditFile.Close()
+	}
+}
+
+func parseTrustedProxy(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("TrustedProxies: can't read \"%s\" as an address", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("TrustedProxies: %s", err.Error())
+	}
+	return network, nil
+}
+
+// reads name:role:hash lines, skipping blank lines and # comments
+func readUsersFile(filename string) (map[string] *localUser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string] *localUser)
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:role:hash", filename, lineNumber)
+		}
+		role, err := parseRole(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, lineNumber, err.Error())
+		}
+		if _, err := bcrypt.Cost([]byte(parts[2])); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a bcrypt hash", filename, lineNumber)
+		}
+		users[parts[0]] = &localUser{role, []byte(parts[2])}
+	}
+
+	return users, scanner.Err()
+}
+
+func (a *Authenticator) Enabled() bool {
+	return a.settings.Load().mode != ""
+}
+
+// the user making the request, or nil if they haven't signed in.  Without
+// auth, everyone is an anonymous admin.
+func (a *Authenticator) User(r *http.Request) *User {
+	settings := a.settings.Load()
+	switch settings.mode {
+	case "":
+		return &User{"", ROLE_ADMIN}
+
+	case "proxy":
+		if !settings.fromTrustedProxy(r) {
+			return nil
+		}
+		name := r.Header.Get(settings.userHeader)
+		if name == "" {
+			return nil
+		}
+		role := settings.defaultRole
+		if settings.roleHeader != "" {
+			if s := r.Header.Get(settings.roleHeader); s != "" {
+				parsed, err := parseRole(s)
+				if err != nil {
+					return nil
+				}
+				role = parsed
+			}
+		}
+		return &User{name, role}
+	}
+
+	// scripts can use basic auth rather than signing in
+	if name, password, ok := r.BasicAuth(); ok {
+		return settings.checkPassword(name, password)
+	}
+
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	s, exists := a.sessions[cookie.Value]
+	if !exists {
+		return nil
+	}
+	// the users file may have changed since they signed in
+	user, current := settings.users[s.user.Name]
+	if !current || time.Now().After(s.expires) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	return &User{s.user.Name, user.role}
+}
+
+func (a *authSettings) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// eg a unix socket, which has no address to check
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range(a.trusted) {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *authSettings) checkPassword(name string, password string) *User {
+	user, exists := a.users[name]
+	if !exists {
+		// take as long as a wrong password would, so names can't be probed
+		unknownUserOnce.Do(func() {
+			unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil
+	}
+	return &User{name, user.role}
+}
+
+var unknownUserOnce sync.Once
+var unknownUserHash []byte
+
+func (a *Authenticator) newSession(user *User) string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	for other, s := range(a.sessions) {
+		if now.After(s.expires) {
+			delete(a.sessions, other)
+		}
+	}
+	a.sessions[id] = &session{user, now.Add(a.settings.Load().sessionLength)}
+	return id
+}
+
+func (a *Authenticator) endSession(r *http.Request) {
+	if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+		a.mutex.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mutex.Unlock()
+	}
+}
+
+type userContextKey struct{}
+
+// the user Require let through
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey{}).(*User)
+	return user
+}
+
+// the user's name for incident timelines and the like
+func (u *User) String() string {
+	if u == nil || u.Name == "" {
+		return ""
+	}
+	return u.Name
+}
+
+// wraps handler so it only runs for users with role.  Anyone else who
+// hasn't signed in is sent to the login page (or gets a 401, for anything
+// but a page), and anyone signed in gets a 403.
+func (a *Authenticator) Require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.User(r)
+		if user == nil {
+			mode := a.settings.Load().mode
+			if mode == "users" && r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			if mode == "users" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gospoke"`)
+			}
+			http.Error(w, "Sign in required", http.StatusUnauthorized)
+			return
+		}
+		if !user.Can(role) {
+			http.Error(w, "This needs the "+role.String()+" role", http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// as Require, for handlers which change something: only POSTs from the
+// hub's own pages or from scripts are allowed
+func (a *Authenticator) RequirePost(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return a.Require(role, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if crossSite(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// records a change made by user
+func (a *Authenticator) Audit(user *User, action string, format string, args ...interface{}) {
+	a.settings.Load().audit.Printf("%s %s: %s", describeUser(user.String()), action, fmt.Sprintf(format, args...))
+}
+
+// path if it's a path within the hub, or else "/", so that signing in can't
+// send anyone to another site.  Browsers read a backslash as a slash, so
+// "/\evil.example" is as much another site as "//evil.example".
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "\\\r\n") {
+		return "/"
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
+	return path
+}
+
+// whether a browser sent the request from another site.  Browsers say
+// where a POST came from in Origin (and newer ones in Sec-Fetch-Site);
+// scripts send neither.  Browsers send saved basic auth credentials along
+// with a cross-site form, as well as cookies, so changes made that way are
+// refused whichever way the user signed in.
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+func (a *Authenticator) login(w http.ResponseWriter, r *http.Request, views *viewSet) {
+	next := localPath(r.FormValue("next"))
+
+	settings := a.settings.Load()
+	if settings.mode != "users" {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	failed := false
+	if r.Method == "POST" {
+		name := r.FormValue("name")
+		if user := settings.checkPassword(name, r.FormValue("password")); user != nil {
+			http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE,
+				Value: a.newSession(user),
+				Path: "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+				Expires: time.Now().Add(settings.sessionLength)})
+			a.Audit(user, "login", "signed in from %s", r.RemoteAddr)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		log.Println("failed login for \""+name+"\" from "+r.RemoteAddr)
+		failed = true
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	if err := views.Render(w, "login.tpl", map[string]interface{}{"next": next, "failed": failed}); err != nil {
+		log.Println(err)
+	}
+}
+
+func (a *Authenticator) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.endSession(r)
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// prints a bcrypt hash of the password read from stdin, for UsersFile
+func hashPassword() error {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(hash))
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setupAuth(c *C, auth string, users string) *Authenticator {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "users"), []byte(users), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "gospoke.json"), []byte(`{"Auth":`+auth+`}`), 0644)
+
+	conf, err := readConfig(filepath.Join(dir, "gospoke.json"))
+	c.Assert(err, IsNil)
+	a, err := newAuthenticator(conf)
+	c.Assert(err, IsNil)
+	return a
+}
+
+func userLine(c *C, name string, role string, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	c.Assert(err, IsNil)
+	return name+":"+role+":"+string(hash)+"\n"
+}
+
+// the status of a request through handlers needing role, and the user
+// the handler saw
+func authRequest(a *Authenticator, role Role, r *http.Request) (int, *User) {
+	var seen *User
+	handler := a.RequirePost(role, func(w http.ResponseWriter, r *http.Request) {
+		seen = requestUser(r)
+	})
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Code, seen
+}
+
+func (s *S) TestAuthUsers(c *C) {
+	a := setupAuth(c, `{"UsersFile":"users"}`, "# operators\n"+userLine(c, "alice", "operator", "secret")+userLine(c, "bob", "viewer", "hunter2"))
+
+	r := httptest.NewRequest("POST", "/disable-service", nil)
+	code, _ := authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+
+	r.SetBasicAuth("alice", "wrong")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+
+	r.SetBasicAuth("alice", "secret")
+	code, user := authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(*user, Equals, User{"alice", ROLE_OPERATOR})
+
+	code, _ = authRequest(a, ROLE_ADMIN, r)
+	c.Assert(code, Equals, http.StatusForbidden)
+
+	r = httptest.NewRequest("GET", "/disable-service", nil)
+	r.SetBasicAuth("alice", "secret")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusMethodNotAllowed)
+
+	r = httptest.NewRequest("POST", "/disable-service", nil)
+	r.SetBasicAuth("bob", "hunter2")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAuthSession(c *C) {
+	a := setupAuth(c, `{"UsersFile":"users"}`, userLine(c, "alice", "admin", "secret"))
+	views := newViewSet(resourceFS(""), false)
+
+	form := url.Values{"name": {"alice"}, "password": {"secret"}, "next": {"//evil.example"}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	a.login(w, r, views)
+	c.Assert(w.Code, Equals, http.StatusSeeOther)
+	c.Assert(w.Header().Get("Location"), Equals, "/")
+
+	cookies := w.Result().Cookies()
+	c.Assert(len(cookies), Equals, 1)
+
+	r = httptest.NewRequest("POST", "/reload-config", nil)
+	r.AddCookie(cookies[0])
+	code, user := authRequest(a, ROLE_ADMIN, r)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(user.Name, Equals, "alice")
+
+	w = httptest.NewRecorder()
+	a.logout(w, r)
+	code, _ = authRequest(a, ROLE_VIEWER, r)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestAuthProxy(c *C) {
+	a := setupAuth(c, `{"Mode":"proxy", "RoleHeader":"X-Role", "TrustedProxies":["10.0.0.0/8"]}`, "")
+
+	r := httptest.NewRequest("POST", "/add-silence", nil)
+	r.RemoteAddr = "10.1.2.3:5000"
+	r.Header.Set("X-Forwarded-User", "carol")
+	code, user := authRequest(a, ROLE_VIEWER, r)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(*user, Equals, User{"carol", ROLE_VIEWER})
+
+	r.Header.Set("X-Role", "operator")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusOK)
+
+	// anyone else could claim to be anybody
+	r.RemoteAddr = "192.168.1.1:5000"
+	code, _ = authRequest(a, ROLE_VIEWER, r)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestAuthConfigErrors(c *C) {
+	for _, auth := range([]string{`{"Mode":"ldap"}`, `{}`, `{"UsersFile":"missing"}`, `{"Mode":"proxy"}`,
+			`{"Mode":"proxy", "TrustedProxies":["nowhere"]}`, `{"Mode":"proxy", "TrustedProxies":["::1"], "DefaultRole":"root"}`}) {
+		dir, _ := ioutil.TempDir("", "gospoke")
+		ioutil.WriteFile(filepath.Join(dir, "gospoke.json"), []byte(`{"Auth":`+auth+`}`), 0644)
+		conf, err := readConfig(filepath.Join(dir, "gospoke.json"))
+		c.Assert(err, IsNil)
+		_, err = newAuthenticator(conf)
+		c.Assert(err, NotNil)
+		os.RemoveAll(dir)
+	}
+
+	_, err := readUsersFile("/dev/null")
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestLoginStaysOnHub(c *C) {
+	for _, next := range([]string{"//evil.example", "/\\evil.example", "/\\/evil.example", "http://evil.example/", "evil.example"}) {
+		c.Assert(localPath(next), Equals, "/")
+	}
+	c.Assert(localPath("/service?name=db"), Equals, "/service?name=db")
+}
+
+func (s *S) TestAuthRefusesCrossSite(c *C) {
+	a := setupAuth(c, `{"UsersFile":"users"}`, userLine(c, "alice", "operator", "secret"))
+
+	// a script, and the hub's own form
+	r := httptest.NewRequest("POST", "http://hub.example/disable-service", nil)
+	r.SetBasicAuth("alice", "secret")
+	code, _ := authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusOK)
+	r.Header.Set("Origin", "http://hub.example")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusOK)
+
+	// a form on another site, which the browser sends credentials with
+	r.Header.Set("Origin", "https://evil.example")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusForbidden)
+
+	r.Header.Del("Origin")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	code, _ = authRequest(a, ROLE_OPERATOR, r)
+	c.Assert(code, Equals, http.StatusForbidden)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"time"
+	)
+
+// The hub publishes what happens to it (heartbeats, status changes, log
+// entries, notifications, incidents and silences) so that web clients and
+// consoles can update without polling.  Events
+// are published on the timeline thread, so subscribers must never block
+// it: each has a buffered channel and events which don't fit are dropped
+// for that subscriber.
+
+const SUBSCRIBER_BUFFER = 64
+
+type HubEvent struct {
+	// "heartbeat", "status", "log", "notification", "incident" or "silence"
+	Type string
+	// the service the event is about and its group, or "" for
+	// notifications and silences
+	Service string
+	Group string
+	Data map[string] interface{}
+}
+
+type Broadcaster struct {
+	mutex sync.Mutex
+	subscribers map[chan *HubEvent] bool
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: make(map[chan *HubEvent] bool)}
+}
+
+func (b *Broadcaster) Subscribe() chan *HubEvent {
+	c := make(chan *HubEvent, SUBSCRIBER_BUFFER)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers[c] = true
+
+	return c
+}
+
+// stops delivery to c and closes it
+func (b *Broadcaster) Unsubscribe(c chan *HubEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers[c] {
+		delete(b.subscribers, c)
+		close(c)
+	}
+}
+
+func (b *Broadcaster) Publish(e *HubEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for c, _ := range(b.subscribers) {
+		select {
+		case c <- e:
+		default:
+			// a slow client misses events rather than holding up the hub
+		}
+	}
+}
+
+func statusName(status int) string {
+	switch status {
+	case STATUS_UP:
+		return "up"
+	case STATUS_DOWN:
+		return "down"
+	}
+	return "unknown"
+}
+
+func (h *ServiceHub) publishHeartbeat(s *Service) {
+	h.events.Publish(&HubEvent{"heartbeat", s.Name, s.Group, map[string] interface{}{
+		"service": s.Name,
+		"count": s.HeartbeatCount,
+		"last_heartbeat": s.LastHeartbeatTimestamp.Format(time.Kitchen)}})
+}
+
+// published when a service goes up or down
+func (h *ServiceHub) publishStatus(s *Service, previous int) {
+	h.events.Publish(&HubEvent{"status", s.Name, s.Group, map[string] interface{}{
+		"service": s.Name,
+		"status": statusName(s.Status),
+		"previous": statusName(previous)}})
+}
+
+// published for new entries and again each time a repeat is counted
+func (h *ServiceHub) publishLogEntry(entry *LogEntry) {
+	group := ""
+	if s, exists := h.services[entry.ServiceName]; exists {
+		group = s.Group
+	}
+
+	h.events.Publish(&HubEvent{"log", entry.ServiceName, group, map[string] interface{}{
+		"service": entry.ServiceName,
+		"id": entry.Sequence,
+		"severity": entry.Severity,
+		"severity_name": h.severities.Name(entry.Severity),
+		"color": h.severities.Color(entry.Severity),
+		"summary": entry.Summary,
+		"count": entry.Count,
+		"timestamp": entry.Timestamp,
+		"last_seen": entry.LastSeen}})
+}
+
+func (h *ServiceHub) publishNotification(command string, msg string) {
+	h.events.Publish(&HubEvent{"notification", "", "", map[string] interface{}{
+		"command": command,
+		"message": msg}})
+}
+
+// action is what happened to the incident, eg "opened" or "acknowledged"
+func (h *ServiceHub) publishIncident(incident *Incident, action string, by string) {
+	group := ""
+	if s, exists := h.services[incident.ServiceName]; exists {
+		group = s.Group
+	}
+
+	h.events.Publish(&HubEvent{"incident", incident.ServiceName, group, map[string] interface{}{
+		"id": incident.Id,
+		"service": incident.ServiceName,
+		"action": action,
+		"state": incident.State,
+		"title": incident.Title,
+		"assignee": incident.Assignee,
+		"by": by}})
+}
+
+// action is "added" or "removed"
+func (h *ServiceHub) publishSilence(silence *Silence, action string) {
+	h.events.Publish(&HubEvent{"silence", "", "", map[string] interface{}{
+		"id": silence.Id,
+		"action": action,
+		"selector": silence.Selector.String(),
+		"comment": silence.Comment,
+		"until": silence.Until}})
+}
+
+func (a *ServiceHubAdapter) Subscribe() chan *HubEvent {
+	return a.hub.events.Subscribe()
+}
+
+func (a *ServiceHubAdapter) Unsubscribe(c chan *HubEvent) {
+	a.hub.events.Unsubscribe(c)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"bytes"
+	"time"
+)
+
+func (s *S) TestBroadcastHubEvents(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+
+	events := hub.events.Subscribe()
+	hub.Heartbeat("a")
+	hub.Log("a", "disk full", WARN, now)
+
+	e := <-events
+	c.Assert(e.Type, Equals, "status")
+	c.Assert(e.Data["status"], Equals, "up")
+	c.Assert(e.Data["previous"], Equals, "unknown")
+
+	e = <-events
+	c.Assert(e.Type, Equals, "heartbeat")
+
+	e = <-events
+	c.Assert(e.Type, Equals, "incident")
+	c.Assert(e.Data["action"], Equals, "opened")
+
+	e = <-events
+	c.Assert(e.Type, Equals, "log")
+	c.Assert(e.Data["summary"], Equals, "disk full")
+
+	e = <-events
+	c.Assert(e.Type, Equals, "notification")
+	c.Assert(e.Data["message"], Equals, "a: disk full")
+
+	hub.events.Unsubscribe(events)
+	_, open := <-events
+	c.Assert(open, Equals, false)
+}
+
+func (s *S) TestBroadcastDropsForSlowSubscribers(c *C) {
+	b := NewBroadcaster()
+	events := b.Subscribe()
+
+	for i := 0; i < SUBSCRIBER_BUFFER + 10; i++ {
+		b.Publish(&HubEvent{Type: "log"})
+	}
+	c.Assert(len(events), Equals, SUBSCRIBER_BUFFER)
+}
+
+func (s *S) TestWriteSseEvent(c *C) {
+	b := new(bytes.Buffer)
+	writeSseEvent(b, &HubEvent{"status", "a", "", map[string] interface{}{"service": "a", "status": "down"}})
+	c.Assert(b.String(), Equals, "event: status\ndata: {\"service\":\"a\",\"status\":\"down\"}\n\n")
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+	)
+
+type optionsDef struct {
+	NotifierThrottle int `yaml:"NotifierThrottle"`
+	NotifierCommand string `yaml:"NotifierCommand"`
+	// text/template used for each notification message, applied to the 
+	// LogEntry
+	NotificationTemplate string `yaml:"NotificationTemplate"`
+	// seconds within which repeats of a log entry are counted rather than
+	// logged again.  Defaults to 60, 0 turns deduplication off.
+	DedupWindow *int `yaml:"DedupWindow"`
+	Listen string `yaml:"Listen"`
+	UdpListen string `yaml:"UdpListen"`
+	UdpSecret string `yaml:"UdpSecret"`
+	UnixSockets []unixSocketDef `yaml:"UnixSockets"`
+	// serve the web UI from this directory rather than the copy built into
+	// the binary
+	ResourceDir string `yaml:"ResourceDir"`
+	Services []serviceDef `yaml:"Services"`
+	// files or globs whose Services are appended to Services
+	Include []string `yaml:"Include"`
+	AutoRegister *autoRegisterDef `yaml:"AutoRegister"`
+	// defaults for every service in the group
+	Groups map[string] groupDef `yaml:"Groups"`
+	// named sets of settings which services can refer to with Template
+	Templates map[string] serviceDef `yaml:"Templates"`
+	// named notification commands which services can send to instead of 
+	// NotifierCommand
+	Channels map[string] channelDef `yaml:"Channels"`
+	// levels in addition to the built-in OKAY..FATAL
+	Severities []severityDef `yaml:"Severities"`
+	// who may use the web UI; open to everyone if unset.  See auth.go.
+	Auth *authDef `yaml:"Auth"`
+	// bearer tokens for /jsonrpc, and whether every request needs one.  See
+	// tokens.go.
+	ApiTokens []tokenDef `yaml:"ApiTokens"`
+	RequireApiToken bool `yaml:"RequireApiToken"`
+
+	// where the config was loaded from, for error messages
+	filename string
+	data []byte
+	autoRegisterLine int
+}
+
+// services which send a heartbeat or log without being listed in Services
+// are created from Template, if their name matches one of the Allow
+// expressions (or Allow is empty)
+type autoRegisterDef struct {
+	Allow []string `yaml:"Allow"`
+	Template serviceDef `yaml:"Template"`
+}
+
+type groupDef struct {
+	Timeout int `yaml:"Timeout"`
+	Enabled *bool `yaml:"Enabled"`
+	NotificationsStop *string `yaml:"NotificationsStop"`
+	NotificationsStart *string `yaml:"NotificationsStart"`
+	NotifySeverity *string `yaml:"NotifySeverity"`
+	Channels []string `yaml:"Channels"`
+	// added to each service in the group, unless the service sets the 
+	// same label
+	Labels map[string] string `yaml:"Labels"`
+	// prepended to the description of each service in the group
+	DescriptionPrefix string `yaml:"DescriptionPrefix"`
+}
+
+type channelDef struct {
+	Command string `yaml:"Command"`
+	// label selector for services which should notify this channel in 
+	// addition to the channels they list
+	Selector string `yaml:"Selector"`
+}
+
+type severityDef struct {
+	Name string `yaml:"Name"`
+	Level int `yaml:"Level"`
+	Color string `yaml:"Color"`
+}
+
+type unixSocketDef struct {
+	// relative to the directory of the config
+	Path string `yaml:"Path"`
+	// octal file mode, eg "0660"; 0600 if unset
+	Mode *string `yaml:"Mode"`
+}
+
+type serviceDef struct {
+	Name string `yaml:"Name"`
+	Group *string `yaml:"Group"`
+	Timeout int `yaml:"Timeout"`
+	Enabled *bool `yaml:"Enabled"`
+	Description *string `yaml:"Description"`
+	Link string `yaml:"Link"`
+	NotificationsStop *string `yaml:"NotificationsStop"`
+	NotificationsStart *string `yaml:"NotificationsStart"`
+	// lowest severity, by name or number, which sends notifications.
+	// Defaults to WARN.
+	NotifySeverity *string `yaml:"NotifySeverity"`
+	PingToken *string `yaml:"PingToken"`
+	Channels []string `yaml:"Channels"`
+	// names of services which must be up for this one to work
+	DependsOn []string `yaml:"DependsOn"`
+	Labels map[string] string `yaml:"Labels"`
+	// name of an entry in Templates to take unspecified settings from
+	Template *string `yaml:"Template"`
+
+	// where the definition starts, for error messages
+	filename string
+	line int
+}
+
+// a problem found while loading the config.  line is 0 if the location 
+// couldn't be determined
+type configError struct {
+	filename string
+	line int
+	msg string
+}
+
+func (e *configError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.filename, e.line, e.msg)
+	}
+	return e.filename+": "+e.msg
+}
+
+type configErrors []*configError
+
+func (e configErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range(e) {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// parses "HH:MM" into minutes since midnight.  "24:00" is allowed to mean
+// the end of the day.
+func parseTimeOfDay(tstr string) (int, error) {
+	parts := strings.SplitN(tstr, ":", 2)
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, errors.New("invalid time \""+tstr+"\", expected HH:MM")
+	}
+
+	hour, herr := strconv.Atoi(parts[0])
+	minute, merr := strconv.Atoi(parts[1])
+	if herr != nil || merr != nil || hour < 0 || minute < 0 || minute > 59 || hour * 60 + minute > 24 * 60 {
+		return 0, errors.New("invalid time \""+tstr+"\", expected HH:MM between 00:00 and 24:00")
+	}
+
+	return hour * 60 + minute, nil
+}
+
+// settings for a service which doesn't specify anything but its name
+func defaultServiceConfig(name string) *ServiceConfig {
+	return &ServiceConfig{Name: name, 
+		Group: "default",
+		NotificationFirstMinute: 0,
+		NotificationLastMinute: 24 * 60}
+}
+
+// fills in defaults for anything not specified in the service definition
+func newServiceConfig(s *serviceDef, severities *SeverityTable) (*ServiceConfig, error) {
+	config := defaultServiceConfig(s.Name)
+
+	if s.Timeout <= 0 {
+		return nil, errors.New("Timeout must be a positive number of seconds")
+	}
+	config.Timeout = time.Duration(s.Timeout) * time.Second
+
+	if s.Group != nil { 
+		config.Group = *s.Group
+	}
+
+	if s.Description != nil {
+		config.Description = *s.Description
+	}
+
+	config.Link = s.Link
+
+	if s.Enabled != nil {
+		config.Enabled = *s.Enabled
+	}
+
+	var err error
+	if s.NotificationsStart != nil {
+		config.NotificationFirstMinute, err = parseTimeOfDay(*s.NotificationsStart)
+		if err != nil {
+			return nil, errors.New("NotificationsStart: "+err.Error())
+		}
+	}
+
+	if s.NotificationsStop != nil {
+		config.NotificationLastMinute, err = parseTimeOfDay(*s.NotificationsStop)
+		if err != nil {
+			return nil, errors.New("NotificationsStop: "+err.Error())
+		}
+	}
+
+	if config.NotificationFirstMinute > config.NotificationLastMinute {
+		return nil, errors.New("NotificationsStart must not be after NotificationsStop")
+	}
+
+	if s.NotifySeverity != nil {
+		severity, err := severities.Parse(*s.NotifySeverity)
+		if err != nil {
+			return nil, errors.New("NotifySeverity: "+err.Error())
+		}
+		config.NotifySeverity = &severity
+	}
+
+	if s.PingToken != nil {
+		config.PingToken = *s.PingToken
+	}
+
+	config.Channels = s.Channels
+	config.DependsOn = s.DependsOn
+
+	for key, _ := range(s.Labels) {
+		if !isValidLabelKey(key) {
+			return nil, errors.New("invalid label name \""+key+"\"")
+		}
+	}
+	config.Labels = s.Labels
+
+	return config, nil
+}
+
+// fills in any settings missing from s with those from defaults
+func (s *serviceDef) inherit(defaults *serviceDef) {
+	if s.Group == nil {
+		s.Group = defaults.Group
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaults.Timeout
+	}
+	if s.Enabled == nil {
+		s.Enabled = defaults.Enabled
+	}
+	if s.Description == nil {
+		s.Description = defaults.Description
+	}
+	if s.Link == "" {
+		s.Link = defaults.Link
+	}
+	if s.NotificationsStart == nil {
+		s.NotificationsStart = defaults.NotificationsStart
+	}
+	if s.NotificationsStop == nil {
+		s.NotificationsStop = defaults.NotificationsStop
+	}
+	if s.NotifySeverity == nil {
+		s.NotifySeverity = defaults.NotifySeverity
+	}
+	if s.PingToken == nil {
+		s.PingToken = defaults.PingToken
+	}
+	if s.Channels == nil {
+		s.Channels = defaults.Channels
+	}
+	if s.DependsOn == nil {
+		s.DependsOn = defaults.DependsOn
+	}
+	if len(defaults.Labels) > 0 {
+		labels := make(map[string] string)
+		for k, v := range(defaults.Labels) {
+			labels[k] = v
+		}
+		for k, v := range(s.Labels) {
+			labels[k] = v
+		}
+		s.Labels = labels
+	}
+}
+
+// applies the service's template and then its group's defaults.  Settings
+// on the service win over the template, which wins over the group.
+func (conf *optionsDef) resolveServiceDef(def *serviceDef) (*serviceDef, error) {
+	resolved := *def
+
+	if def.Template != nil {
+		template, exists := conf.Templates[*def.Template]
+		if !exists {
+			return nil, errors.New("no template named \""+*def.Template+"\"")
+		}
+		resolved.inherit(&template)
+	}
+
+	groupName := "default"
+	if resolved.Group != nil {
+		groupName = *resolved.Group
+	}
+
+	if group, exists := conf.Groups[groupName]; exists {
+		resolved.inherit(&serviceDef{Timeout: group.Timeout,
+			Enabled: group.Enabled,
+			NotificationsStart: group.NotificationsStart,
+			NotificationsStop: group.NotificationsStop,
+			NotifySeverity: group.NotifySeverity,
+			Channels: group.Channels,
+			Labels: group.Labels})
+
+		if group.DescriptionPrefix != "" {
+			description := group.DescriptionPrefix
+			if resolved.Description != nil {
+				description += *resolved.Description
+			}
+			resolved.Description = &description
+		}
+	}
+
+	for _, channel := range(resolved.Channels) {
+		if _, exists := conf.Channels[channel]; !exists && channel != DEFAULT_CHANNEL {
+			return nil, errors.New("no channel named \""+channel+"\"")
+		}
+	}
+
+	return &resolved, nil
+}
+
+func newAutoRegisterPolicy(conf *optionsDef, def *autoRegisterDef, severities *SeverityTable) (*AutoRegisterPolicy, error) {
+	resolved, err := conf.resolveServiceDef(&def.Template)
+	if err != nil {
+		return nil, errors.New("Template: "+err.Error())
+	}
+
+	template, err := newServiceConfig(resolved, severities)
+	if err != nil {
+		return nil, errors.New("Template: "+err.Error())
+	}
+
+	policy := &AutoRegisterPolicy{Template: *template}
+
+	for _, exprString := range(def.Allow) {
+		expr, err := regexp.Compile(exprString)
+		if err != nil {
+			return nil, errors.New("Allow: "+err.Error())
+		}
+		policy.Allow = append(policy.Allow, expr)
+	}
+
+	return policy, nil
+}
+
+// validates the config and converts the parts of it which can be changed 
+// without a restart.  All problems are reported, not just the first.
+func newHubConfig(conf *optionsDef) (*HubConfig, error) {
+	hubConfig := &HubConfig{NotifierCommand: conf.NotifierCommand,
+		NotifierThrottle: time.Duration(conf.NotifierThrottle) * time.Second}
+
+	errs := make(configErrors, 0)
+	fail := func(filename string, line int, msg string) {
+		errs = append(errs, &configError{filename, line, msg})
+	}
+
+	if conf.NotifierThrottle < 0 {
+		fail(conf.filename, 0, "NotifierThrottle must not be negative")
+	}
+
+	hubConfig.DedupWindow = DEFAULT_DEDUP_WINDOW
+	if conf.DedupWindow != nil {
+		if *conf.DedupWindow < 0 {
+			fail(conf.filename, lineOfText(conf.data, "DedupWindow"), "DedupWindow must not be negative")
+		}
+		hubConfig.DedupWindow = time.Duration(*conf.DedupWindow) * time.Second
+	}
+
+	custom := make([]*SeverityLevel, 0, len(conf.Severities))
+	for _, def := range(conf.Severities) {
+		custom = append(custom, &SeverityLevel{def.Name, def.Level, def.Color})
+	}
+	severities, err := NewSeverityTable(custom)
+	if err != nil {
+		fail(conf.filename, lineOfText(conf.data, "Severities"), "Severities: "+err.Error())
+		// carry on with the built-in levels so services are still checked
+		severities = defaultSeverities
+	}
+	hubConfig.Severities = severities
+
+	firstDefinition := make(map[string] *serviceDef)
+	for i, _ := range(conf.Services) {
+		s := &conf.Services[i]
+
+		if s.Name == "" {
+			fail(s.filename, s.line, "service is missing a Name")
+			continue
+		}
+
+		if first, exists := firstDefinition[s.Name]; exists {
+			where := fmt.Sprintf("line %d", first.line)
+			if first.filename != s.filename {
+				where = fmt.Sprintf("%s:%d", first.filename, first.line)
+			}
+			fail(s.filename, s.line, fmt.Sprintf("duplicate service name \"%s\" (first defined on %s)", s.Name, where))
+			continue
+		}
+		firstDefinition[s.Name] = s
+
+		resolved, err := conf.resolveServiceDef(s)
+		if err != nil {
+			fail(s.filename, s.line, "service \""+s.Name+"\": "+err.Error())
+			continue
+		}
+
+		config, err := newServiceConfig(resolved, hubConfig.Severities)
+		if err != nil {
+			fail(s.filename, s.line, "service \""+s.Name+"\": "+err.Error())
+			continue
+		}
+		hubConfig.Services = append(hubConfig.Services, config)
+	}
+
+	dependencies := make(map[string] []string)
+	for _, config := range(hubConfig.Services) {
+		s := firstDefinition[config.Name]
+		for _, upstream := range(config.DependsOn) {
+			if _, exists := firstDefinition[upstream]; !exists {
+				fail(s.filename, s.line, "service \""+config.Name+"\" depends on unknown service \""+upstream+"\"")
+			}
+		}
+		dependencies[config.Name] = config.DependsOn
+	}
+	if cycle := findDependencyCycle(dependencies); cycle != nil {
+		s := firstDefinition[cycle[0]]
+		fail(s.filename, s.line, describeCycle(cycle))
+	}
+
+	if conf.NotificationTemplate != "" {
+		t, err := template.New("notification").Option("missingkey=zero").Parse(conf.NotificationTemplate)
+		if err != nil {
+			fail(conf.filename, lineOfText(conf.data, "NotificationTemplate"), "NotificationTemplate: "+err.Error())
+		}
+		hubConfig.NotificationTemplate = t
+	}
+
+	hubConfig.Channels = make(map[string] *NotificationChannel)
+	for name, channel := range(conf.Channels) {
+		if channel.Command == "" {
+			fail(conf.filename, lineOfText(conf.data, name), "channel \""+name+"\" is missing a Command")
+		}
+		selector, err := ParseLabelSelector(channel.Selector)
+		if err != nil {
+			fail(conf.filename, lineOfText(conf.data, name), "channel \""+name+"\": "+err.Error())
+		}
+		hubConfig.Channels[name] = &NotificationChannel{channel.Command, selector}
+	}
+
+	if conf.AutoRegister != nil {
+		policy, err := newAutoRegisterPolicy(conf, conf.AutoRegister, hubConfig.Severities)
+		if err != nil {
+			fail(conf.filename, conf.autoRegisterLine, "AutoRegister: "+err.Error())
+		}
+		hubConfig.AutoRegister = policy
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return hubConfig, nil
+}
+
+// re-reads the config file and applies it to the running hub, along with
+// Auth and its UsersFile, ApiTokens, RequireApiToken and UdpSecret.
+// Listen, UdpListen, UnixSockets and ResourceDir are only read at startup.
+type configReloader struct {
+	// held by Reload, so a SIGHUP and /reload-config can't mix two files
+	mutex sync.Mutex
+	filename string
+	hub ThreadSafeServiceHub
+	auth *Authenticator
+	tokens *TokenStore
+	// nil unless UdpListen was set
+	udp *UdpListener
+	// the config read at startup
+	started *optionsDef
+}
+
+// reloads the config, returning warnings about changes which need a
+// restart.  Nothing is changed if the config has errors.
+func (c *configReloader) Reload() ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	conf, err := readConfig(c.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	hubConfig, err := newHubConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthSettings(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := newTokenStore(conf, c.auth)
+	if err != nil {
+		return nil, err
+	}
+	if err := tokens.setMethods(c.tokens.knownMethods()); err != nil {
+		return nil, err
+	}
+
+	if apiErr := c.hub.ApplyConfig(hubConfig); apiErr != nil {
+		return nil, errors.New(apiErr.String())
+	}
+
+	warnings := make([]string, 0)
+	// the hub has changed now, so rather than keep the old Auth, audit to
+	// the main log
+	if err := auth.openAuditLog(c.auth.settings.Load()); err != nil {
+		warnings = append(warnings, "can't open AuditLog, so auditing to the main log: "+err.Error())
+	}
+	c.auth.reload(auth)
+	c.tokens.reload(tokens)
+	if c.udp != nil {
+		if conf.UdpSecret == "" {
+			log.Println("warning: no UdpSecret in the config, so anyone who can reach "+c.started.UdpListen+" can send heartbeats and logs")
+		}
+		c.udp.SetSecret(conf.UdpSecret)
+	}
+
+	restart := func(setting string, changed bool) {
+		if changed {
+			warnings = append(warnings, setting+" has changed, which needs a restart")
+		}
+	}
+	restart("Listen", conf.Listen != c.started.Listen)
+	restart("UdpListen", conf.UdpListen != c.started.UdpListen)
+	restart("UnixSockets", !reflect.DeepEqual(conf.UnixSockets, c.started.UnixSockets))
+	restart("ResourceDir", conf.ResourceDir != c.started.ResourceDir)
+
+	log.Println("Reloaded "+c.filename)
+	for _, warning := range(warnings) {
+		log.Println("warning: "+warning)
+	}
+	return warnings, nil
+}
+
+// reloads the config each time the process receives SIGHUP
+func (c *configReloader) ReloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for _ = range(signals) {
+			if _, err := c.Reload(); err != nil {
+				log.Println("Could not reload "+c.filename+": "+err.Error())
+			}
+		}
+	}()
+}
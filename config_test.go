@@ -0,0 +1,408 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func loadConfigString(c *C, text string) (*HubConfig, error) {
+	f, err := ioutil.TempFile("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+
+	f.WriteString(text)
+	f.Close()
+
+	conf, err := readConfig(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return newHubConfig(conf)
+}
+
+// loads text as a config file with the given name, whose extension says
+// what format it's in
+func loadConfigFile(c *C, name string, text string) (*HubConfig, error) {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+
+	conf, err := readConfig(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return newHubConfig(conf)
+}
+
+func (s *S) TestParseTimeOfDay(c *C) {
+	minute, err := parseTimeOfDay("21:40")
+	c.Assert(err, IsNil)
+	c.Assert(minute, Equals, 21*60+40)
+
+	minute, err = parseTimeOfDay("24:00")
+	c.Assert(err, IsNil)
+	c.Assert(minute, Equals, 24*60)
+
+	_, err = parseTimeOfDay("2140")
+	c.Assert(err, NotNil)
+
+	_, err = parseTimeOfDay("21:60")
+	c.Assert(err, NotNil)
+
+	_, err = parseTimeOfDay("24:01")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestConfigUnknownField(c *C) {
+	_, err := loadConfigString(c, `{
+"Services":[
+	{"Name":"Alpha",
+	"Timeout":10,
+	"NotificationStop":"21:40"
+	}
+]}`)
+	c.Assert(err, NotNil)
+	c.Assert(err.(configErrors)[0].line, Equals, 5)
+	c.Assert(err.(configErrors)[0].msg, Equals, "unknown field \"NotificationStop\"")
+}
+
+func (s *S) TestConfigValidation(c *C) {
+	_, err := loadConfigString(c, `{
+"Services":[
+	{"Name":"Alpha", "Timeout":10},
+	{"Name":"Beta", "Timeout":0},
+	{"Name":"Alpha", "Timeout":10},
+	{"Name":"Gamma", "Timeout":10, "NotificationsStart":"9am"}
+],
+"AutoRegister":{"Allow":["("],"Template":{"Timeout":10}}
+}`)
+	c.Assert(err, NotNil)
+
+	errs := err.(configErrors)
+	c.Assert(len(errs), Equals, 4)
+	c.Assert(errs[0].line, Equals, 4)
+	c.Assert(errs[1].line, Equals, 5)
+	c.Assert(errs[1].msg, Equals, "duplicate service name \"Alpha\" (first defined on line 3)")
+	c.Assert(errs[2].line, Equals, 6)
+	c.Assert(errs[3].line, Equals, 8)
+}
+
+func (s *S) TestConfigValid(c *C) {
+	conf, err := loadConfigString(c, `{
+"NotifierThrottle":20,
+"Services":[
+	{"Name":"Alpha", "Timeout":10, "Enabled":true, "NotificationsStop":"21:40", "Link":"http://alpha"}
+]}`)
+	c.Assert(err, IsNil)
+	c.Assert(len(conf.Services), Equals, 1)
+	c.Assert(conf.Services[0].NotificationLastMinute, Equals, 21*60+40)
+	c.Assert(conf.Services[0].Link, Equals, "http://alpha")
+	c.Assert(conf.Services[0].Group, Equals, "default")
+}
+
+func (s *S) TestConfigEnvExpansion(c *C) {
+	os.Setenv("GOSPOKE_TEST_GROUP", "payments")
+	os.Unsetenv("GOSPOKE_TEST_MISSING")
+
+	conf, err := loadConfigString(c, `{"Services":[{"Name":"Alpha", "Timeout":10, "Group":"${GOSPOKE_TEST_GROUP}"}]}`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.Services[0].Group, Equals, "payments")
+
+	_, err = loadConfigString(c, `{
+"NotifierCommand":"${GOSPOKE_TEST_MISSING}"}`)
+	c.Assert(err, NotNil)
+	c.Assert(err.(configErrors)[0].line, Equals, 2)
+}
+
+func (s *S) TestConfigEnvExpansionNeedsNoEscaping(c *C) {
+	os.Setenv("GOSPOKE_TEST_COMMAND", `notify --title "down" C:\alerts`)
+	os.Unsetenv("GOSPOKE_TEST_MISSING")
+
+	conf, err := loadConfigString(c, `{"NotifierCommand":"${GOSPOKE_TEST_COMMAND}", "Services":[]}`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.NotifierCommand, Equals, `notify --title "down" C:\alerts`)
+
+	// only values are expanded, not comments
+	conf, err = loadConfigFile(c, "gospoke.yaml", `# set ${GOSPOKE_TEST_MISSING} to override
+NotifierCommand: ${GOSPOKE_TEST_COMMAND}
+`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.NotifierCommand, Equals, `notify --title "down" C:\alerts`)
+}
+
+func (s *S) TestConfigYaml(c *C) {
+	conf, err := loadConfigFile(c, "gospoke.yml", `NotifierThrottle: 30
+NotifierCommand: ./notify.sh
+Groups:
+  infra:
+    Labels: {team: infra}
+Services:
+  - Name: Alpha
+    Timeout: 10
+    Group: infra
+  - Name: Beta
+    Timeout: 5
+    NotificationsStop: "21:40"
+`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.NotifierThrottle, Equals, 30 * time.Second)
+	c.Assert(conf.NotifierCommand, Equals, "./notify.sh")
+	c.Assert(len(conf.Services), Equals, 2)
+	c.Assert(conf.Services[0].Labels["team"], Equals, "infra")
+	c.Assert(conf.Services[1].NotificationLastMinute, Equals, 21*60+40)
+
+	_, err = loadConfigFile(c, "gospoke.yaml", `Services:
+  - Name: Alpha
+    Timeout: 10
+  - Name: Alpha
+    Timeout: 10
+`)
+	c.Assert(err, NotNil)
+	c.Assert(err.(configErrors)[0].line, Equals, 4)
+}
+
+func (s *S) TestConfigToml(c *C) {
+	conf, err := loadConfigFile(c, "gospoke.toml", `NotifierThrottle = 30
+NotifierCommand = "./notify.sh"
+
+[Groups.infra]
+Labels = {team = "infra"}
+
+[[Services]]
+Name = "Alpha"
+Timeout = 10
+Group = "infra"
+
+[[Services]]
+Name = "Beta"
+Timeout = 5
+NotificationsStop = "21:40"
+`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.NotifierThrottle, Equals, 30 * time.Second)
+	c.Assert(len(conf.Services), Equals, 2)
+	c.Assert(conf.Services[0].Labels["team"], Equals, "infra")
+	c.Assert(conf.Services[1].NotificationLastMinute, Equals, 21*60+40)
+
+	_, err = loadConfigFile(c, "gospoke.toml", `[[Services]]
+Name = "Alpha"
+Timeout = 10
+Colour = "red"
+`)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestConfigInclude(c *C) {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "teams"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "gospoke.json"), []byte(`{"Include":["teams/*.json"], "Services":[{"Name":"Alpha", "Timeout":10}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "teams", "a.json"), []byte(`{"Services":[{"Name":"Beta", "Timeout":10}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "teams", "b.json"), []byte(`{"Services":[
+	{"Name":"Alpha", "Timeout":10}]}`), 0644)
+
+	conf, err := readConfig(filepath.Join(dir, "gospoke.json"))
+	c.Assert(err, IsNil)
+	c.Assert(len(conf.Services), Equals, 3)
+	c.Assert(conf.Services[1].Name, Equals, "Beta")
+
+	_, err = newHubConfig(conf)
+	c.Assert(err, NotNil)
+	c.Assert(err.(configErrors)[0].filename, Equals, filepath.Join(dir, "teams", "b.json"))
+	c.Assert(err.(configErrors)[0].line, Equals, 2)
+}
+
+func (s *S) TestConfigGroupsAndTemplates(c *C) {
+	conf, err := loadConfigString(c, `{
+"Channels":{"ops":{"Command":"./page_ops.sh"}},
+"Groups":{"cron":{"Timeout":3600, "Enabled":true, "Channels":["ops"], "DescriptionPrefix":"Nightly: ", "NotificationsStop":"20:00"}},
+"Templates":{"job":{"Group":"cron", "Description":"batch job"}},
+"Services":[
+	{"Name":"backup", "Template":"job"},
+	{"Name":"reindex", "Template":"job", "Timeout":60, "Description":"reindex"},
+	{"Name":"web", "Timeout":10}
+]}`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.Channels["ops"].Command, Equals, "./page_ops.sh")
+
+	backup := conf.Services[0]
+	c.Assert(backup.Group, Equals, "cron")
+	c.Assert(backup.Timeout, Equals, time.Hour)
+	c.Assert(backup.Enabled, Equals, true)
+	c.Assert(backup.Description, Equals, "Nightly: batch job")
+	c.Assert(backup.NotificationLastMinute, Equals, 20*60)
+	c.Assert(backup.Channels, DeepEquals, []string{"ops"})
+
+	reindex := conf.Services[1]
+	c.Assert(reindex.Timeout, Equals, time.Minute)
+	c.Assert(reindex.Description, Equals, "Nightly: reindex")
+
+	web := conf.Services[2]
+	c.Assert(web.Group, Equals, "default")
+	c.Assert(web.Enabled, Equals, false)
+	c.Assert(len(web.Channels), Equals, 0)
+}
+
+func (s *S) TestConfigUnknownTemplateOrChannel(c *C) {
+	_, err := loadConfigString(c, `{
+"Services":[
+	{"Name":"a", "Timeout":10, "Template":"missing"},
+	{"Name":"b", "Timeout":10, "Channels":["missing"]}
+]}`)
+	c.Assert(err, NotNil)
+	c.Assert(len(err.(configErrors)), Equals, 2)
+}
+
+func (s *S) TestConfigNotificationTemplate(c *C) {
+	conf, err := loadConfigString(c, `{"NotificationTemplate":"{{.ServiceName}} {{.Fields.host}}", "Services":[]}`)
+	c.Assert(err, IsNil)
+	c.Assert(conf.NotificationTemplate, NotNil)
+
+	_, err = loadConfigString(c, `{"NotificationTemplate":"{{.ServiceName", "Services":[]}`)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestConfigSeverities(c *C) {
+	conf, err := loadConfigString(c, `{
+"Severities":[{"Name":"PAGE","Level":10,"Color":"purple"}],
+"Services":[
+	{"Name":"Alpha", "Timeout":10, "NotifySeverity":"page"},
+	{"Name":"Beta", "Timeout":10},
+	{"Name":"Gamma", "Timeout":10, "NotifySeverity":"okay"}
+]}`)
+	c.Assert(err, IsNil)
+	c.Assert(*conf.Services[0].NotifySeverity, Equals, 10)
+	c.Assert(conf.Services[1].NotifySeverity, IsNil)
+	c.Assert(*conf.Services[2].NotifySeverity, Equals, OKAY)
+	c.Assert(conf.Severities.Name(10), Equals, "PAGE")
+
+	_, err = loadConfigString(c, `{"Services":[{"Name":"Alpha", "Timeout":10, "NotifySeverity":"loud"}]}`)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestConfigReload(c *C) {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gospoke.json")
+	write := func(users string, config string) {
+		ioutil.WriteFile(filepath.Join(dir, "users"), []byte(users), 0600)
+		ioutil.WriteFile(filename, []byte(config), 0644)
+	}
+	write(userLine(c, "alice", "operator", "secret")+userLine(c, "bob", "operator", "hunter2"), `{
+"UdpListen": ":9199", "UdpSecret": "first", "UnixSockets": [{"Path": "hub.sock", "Mode": "0660"}],
+"Auth": {"UsersFile": "users"},
+"RequireApiToken": true,
+"ApiTokens": [{"Name": "leaked", "Token": "leaked-0123456789"}, {"Name": "root", "Token": "root-0123456789ab", "Admin": true}],
+"Services": [{"Name": "Alpha", "Timeout": 10}]}`)
+
+	conf, err := readConfig(filename)
+	c.Assert(err, IsNil)
+	auth, err := newAuthenticator(conf)
+	c.Assert(err, IsNil)
+	tokens, err := newTokenStore(conf, auth)
+	c.Assert(err, IsNil)
+	c.Assert(tokens.setMethods([]string{"heartbeat", "log"}), IsNil)
+	_, _, hub := SetupNotifier()
+	udp := NewUdpListener(nil, hub.timeline, conf.UdpSecret)
+	reloader := &configReloader{filename: filename, hub: &syncHub{hub: hub}, auth: auth, tokens: tokens, udp: udp, started: conf}
+
+	created, apiErr := tokens.Create(tokenDef{Name: "made", Admin: true})
+	c.Assert(apiErr, IsNil)
+
+	bearer := func(secret string) *ApiError {
+		r := httptest.NewRequest("POST", "/jsonrpc", nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		_, err := tokens.Authenticate(r)
+		return err
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: auth.newSession(&User{"bob", ROLE_OPERATOR})})
+	c.Assert(auth.User(r), NotNil)
+	c.Assert(bearer("leaked-0123456789"), IsNil)
+
+	// the leaked token and bob go, and the rest is applied
+	write(userLine(c, "alice", "admin", "secret"), `{
+"UdpListen": ":9299", "UdpSecret": "second", "UnixSockets": [{"Path": "hub.sock", "Mode": "0660"}],
+"Auth": {"UsersFile": "users"},
+"RequireApiToken": true,
+"ApiTokens": [{"Name": "root", "Token": "root-0123456789ab", "Admin": true}],
+"Services": [{"Name": "Alpha", "Timeout": 10}, {"Name": "Beta", "Timeout": 10}]}`)
+	warnings, err := reloader.Reload()
+	c.Assert(err, IsNil)
+	c.Assert(warnings, DeepEquals, []string{"UdpListen has changed, which needs a restart"})
+
+	c.Assert(bearer("leaked-0123456789"), NotNil)
+	c.Assert(bearer("root-0123456789ab"), IsNil)
+	c.Assert(bearer(created), IsNil)
+	c.Assert(auth.User(r), IsNil)
+	basic := httptest.NewRequest("GET", "/", nil)
+	basic.SetBasicAuth("alice", "secret")
+	c.Assert(auth.User(basic).Role, Equals, ROLE_ADMIN)
+	c.Assert(string(udp.secret), Equals, "second")
+	c.Assert(hub.services["Beta"], NotNil)
+
+	// a config with errors changes nothing
+	write(userLine(c, "alice", "admin", "secret"), `{"RequireApiToken": true, "Services": []}`)
+	_, err = reloader.Reload()
+	c.Assert(err, NotNil)
+	c.Assert(bearer("root-0123456789ab"), IsNil)
+	c.Assert(hub.services["Beta"], NotNil)
+}
+
+func (s *S) TestConfigReloadAuditLog(c *C) {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gospoke.json")
+	ioutil.WriteFile(filepath.Join(dir, "users"), []byte(userLine(c, "alice", "admin", "secret")), 0600)
+	write := func(auditLog string, tokens string) {
+		ioutil.WriteFile(filename, []byte(`{"Auth": {"UsersFile": "users", "AuditLog": "`+auditLog+`"}, "ApiTokens": [`+tokens+`], "Services": []}`), 0644)
+	}
+	write("first.log", "")
+
+	conf, err := readConfig(filename)
+	c.Assert(err, IsNil)
+	auth, err := newAuthenticator(conf)
+	c.Assert(err, IsNil)
+	tokens, err := newTokenStore(conf, auth)
+	c.Assert(err, IsNil)
+	_, _, hub := SetupNotifier()
+	reloader := &configReloader{filename: filename, hub: &syncHub{hub: hub}, auth: auth, tokens: tokens, started: conf}
+	first := auth.settings.Load().auditFile
+	c.Assert(first, NotNil)
+
+	// the same file is kept open
+	_, err = reloader.Reload()
+	c.Assert(err, IsNil)
+	c.Assert(auth.settings.Load().auditFile == first, Equals, true)
+
+	// a config with errors doesn't open its audit log
+	write("broken.log", `{"Name": "agent", "Token": "agent-0123456789ab"}`)
+	_, err = reloader.Reload()
+	c.Assert(err, NotNil)
+	_, err = os.Stat(filepath.Join(dir, "broken.log"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// and a replaced one is closed
+	write("second.log", "")
+	_, err = reloader.Reload()
+	c.Assert(err, IsNil)
+	_, err = first.Write([]byte("x"))
+	c.Assert(err, NotNil)
+	auth.Audit(&User{"alice", ROLE_ADMIN}, "test", "audited")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "second.log"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, "(?s).*audited.*")
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	)
+
+// Config files may be JSON, YAML (.yaml or .yml) or TOML (.toml).  After
+// parsing, ${NAME} in any string value is replaced by the value of the
+// environment variable NAME so that secrets needn't live in the file.  Files listed (or matched
+// by globs) in Include are read the same way and their Services appended.
+
+// filename relative to the directory of the config, unless it's absolute
+func (conf *optionsDef) resolvePath(filename string) string {
+	if filename != "" && !filepath.IsAbs(filename) {
+		return filepath.Join(filepath.Dir(conf.filename), filename)
+	}
+	return filename
+}
+
+// the contents of a file named in Include
+type includeDef struct {
+	Services []serviceDef `yaml:"Services"`
+}
+
+func lineOfOffset(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// line of the first occurrence of text, or 0 if it doesn't appear
+func lineOfText(data []byte, text string) int {
+	index := bytes.Index(data, []byte(text))
+	if index < 0 {
+		return 0
+	}
+	return lineOfOffset(data, int64(index))
+}
+
+// walks the top level object to find the line each element of the array
+// stored under key starts on
+func jsonArrayElementLines(data []byte, key string) []int {
+	d := json.NewDecoder(bytes.NewReader(data))
+
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil
+		}
+
+		if name, ok := t.(string); !ok || !strings.EqualFold(name, key) {
+			var skip json.RawMessage
+			if d.Decode(&skip) != nil {
+				return nil
+			}
+			continue
+		}
+
+		if t, err := d.Token(); err != nil || t != json.Delim('[') {
+			return nil
+		}
+
+		lines := make([]int, 0, 100)
+		for d.More() {
+			// the offset is just past the previous token, so skip to the 
+			// start of the element
+			offset := d.InputOffset()
+			for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+				offset++
+			}
+			lines = append(lines, lineOfOffset(data, offset))
+
+			var skip json.RawMessage
+			if d.Decode(&skip) != nil {
+				break
+			}
+		}
+		return lines
+	}
+
+	return nil
+}
+
+var unknownFieldPattern = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// turns an error from the json decoder into one with a line number
+func jsonConfigError(filename string, data []byte, err error) *configError {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return &configError{filename, lineOfOffset(data, e.Offset), e.Error()}
+	case *json.UnmarshalTypeError:
+		return &configError{filename, lineOfOffset(data, e.Offset), 
+			fmt.Sprintf("%s should be %s, not %s", e.Field, e.Type, e.Value)}
+	}
+
+	if m := unknownFieldPattern.FindStringSubmatch(err.Error()); m != nil {
+		return &configError{filename, lineOfText(data, "\""+m[1]+"\""), "unknown field \""+m[1]+"\""}
+	}
+
+	return &configError{filename, 0, err.Error()}
+}
+
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// replaces ${NAME} in the decoded strings of target with the value of
+// environment variable NAME.  Values are substituted as they are, so they
+// needn't be escaped for the file's format, and references in comments are
+// ignored.  Referring to a variable which isn't set is an error.
+func expandEnv(filename string, data []byte, target interface{}) configErrors {
+	errs := make(configErrors, 0)
+
+	expandStrings(reflect.ValueOf(target), func(s string) string {
+		return envReferencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+			name := ref[2:len(ref)-1]
+			value, found := os.LookupEnv(name)
+			if !found {
+				errs = append(errs, &configError{filename, lineOfText(data, ref), "environment variable "+name+" is not set"})
+				return ref
+			}
+			return value
+		})
+	})
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// applies expand to every string reachable from v through exported fields,
+// pointers, slices and map values
+func expandStrings(v reflect.Value, expand func(string) string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandStrings(v.Elem(), expand)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				expandStrings(v.Field(i), expand)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			expandStrings(v.Index(i), expand)
+		}
+	case reflect.Map:
+		// map values can't be set in place, so expand a copy
+		for _, key := range(v.MapKeys()) {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			expandStrings(value, expand)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expand(v.String()))
+		}
+	}
+}
+
+func decodeJsonConfig(filename string, data []byte, target interface{}) ([]int, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(target); err != nil {
+		return nil, jsonConfigError(filename, data, err)
+	}
+
+	return jsonArrayElementLines(data, "Services"), nil
+}
+
+func decodeYamlConfig(filename string, data []byte, target interface{}) ([]int, error) {
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+	if err := d.Decode(target); err != nil {
+		// yaml includes the line number in its messages
+		return nil, &configError{filename, 0, err.Error()}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	lines := make([]int, 0, 100)
+	mapping := root.Content[0]
+	for i := 0; i + 1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "Services" && mapping.Content[i+1].Kind == yaml.SequenceNode {
+			for _, element := range(mapping.Content[i+1].Content) {
+				lines = append(lines, element.Line)
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+var tomlServicesPattern = regexp.MustCompile(`(?m)^[ \t]*\[\[[ \t]*Services[ \t]*\]\]`)
+
+func decodeTomlConfig(filename string, data []byte, target interface{}) ([]int, error) {
+	md, err := toml.Decode(string(data), target)
+	if err != nil {
+		return nil, &configError{filename, 0, err.Error()}
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		key := undecoded[0].String()
+		return nil, &configError{filename, lineOfText(data, key[strings.LastIndex(key, ".")+1:]), "unknown field \""+key+"\""}
+	}
+
+	// only [[Services]] tables can be located, not inline arrays
+	lines := make([]int, 0, 100)
+	for _, index := range(tomlServicesPattern.FindAllIndex(data, -1)) {
+		lines = append(lines, lineOfOffset(data, int64(index[0])))
+	}
+
+	return lines, nil
+}
+
+// reads, expands and decodes a config file into target, which must have a
+// Services field.  Returns the line each service definition starts on.
+func decodeConfigFile(filename string, target interface{}) ([]int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []int
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		lines, err = decodeYamlConfig(filename, data, target)
+	case ".toml":
+		lines, err = decodeTomlConfig(filename, data, target)
+	default:
+		lines, err = decodeJsonConfig(filename, data, target)
+	}
+
+	if e, ok := err.(*configError); ok {
+		return nil, configErrors{e}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := expandEnv(filename, data, target); errs != nil {
+		return nil, errs
+	}
+	return lines, nil
+}
+
+func setServiceLocations(services []serviceDef, filename string, lines []int) {
+	for i, _ := range(services) {
+		services[i].filename = filename
+		if i < len(lines) {
+			services[i].line = lines[i]
+		}
+	}
+}
+
+func readConfig(filename string) (*optionsDef, error) {
+	var conf optionsDef
+
+	lines, err := decodeConfigFile(filename, &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.filename = filename
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		conf.data = data
+		conf.autoRegisterLine = lineOfText(data, "AutoRegister")
+	}
+	setServiceLocations(conf.Services, filename, lines)
+
+	// includes are relative to the directory of the main config
+	dir := filepath.Dir(filename)
+	for _, pattern := range(conf.Include) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, configErrors{&configError{filename, 0, "Include: "+err.Error()}}
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, configErrors{&configError{filename, 0, "Include: "+pattern+" does not exist"}}
+		}
+		sort.Strings(matches)
+
+		for _, included := range(matches) {
+			var inc includeDef
+			lines, err := decodeConfigFile(included, &inc)
+			if err != nil {
+				return nil, err
+			}
+			setServiceLocations(inc.Services, included, lines)
+			conf.Services = append(conf.Services, inc.Services...)
+		}
+	}
+
+	return &conf, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	)
+
+// The dashboard's view of the services: which are shown, in what order,
+// and how each group sums up.  Everything comes from query parameters so a
+// view can be bookmarked:
+//
+//   selector   label selector, eg team=payments
+//   q          text in the name or description
+//   problems   "1" to show only services with problems
+//   sort       name (the default), status or events
+//   collapse   a group to show only as its summary; repeatable
+//
+// A service has a problem if it is down or has log entries at WARN or
+// above.  Groups are in name order, with the unnamed group first.
+
+var dashboardSorts = map[string] func(a, b *ServiceSnapshot) int{
+	"name": func(a, b *ServiceSnapshot) int { return 0 },
+	// worst first
+	"status": func(a, b *ServiceSnapshot) int { return compareInts(statusRank(b.Status), statusRank(a.Status)) },
+	// most problems first
+	"events": func(a, b *ServiceSnapshot) int { return compareInts(b.ProblemCount(), a.ProblemCount()) },
+}
+
+type DashboardQuery struct {
+	Selector LabelSelector
+	SelectorString string
+	Text string
+	ProblemsOnly bool
+	Sort string
+	Collapsed map[string] bool
+}
+
+type ServiceGroup struct {
+	Group string
+	// the services shown, which omits healthy ones when showing problems
+	Services []*ServiceSnapshot
+	// over every service in the group matching the selector and text
+	Up int
+	Down int
+	Unknown int
+	// of the worst service: up, unknown or down
+	Worst string
+	Collapsed bool
+	// the dashboard with this group's collapsed state toggled
+	ToggleUrl string
+}
+
+type DashboardSummary struct {
+	Up int
+	Down int
+	Unknown int
+	Problems int
+}
+
+// worse statuses rank higher
+func statusRank(status int) int {
+	switch status {
+	case STATUS_DOWN:
+		return 2
+	case STATUS_UNKNOWN:
+		return 1
+	}
+	return 0
+}
+
+// log entries at WARN or above
+func (s *ServiceSnapshot) ProblemCount() int {
+	count := 0
+	for _, n := range(s.Notifications) {
+		if n.Severity >= WARN {
+			count += n.Count
+		}
+	}
+	return count
+}
+
+func (s *ServiceSnapshot) HasProblem() bool {
+	return s.Status == STATUS_DOWN || s.ProblemCount() > 0
+}
+
+// the query and any error in its selector, which then matches everything
+func parseDashboardQuery(values url.Values) (*DashboardQuery, error) {
+	q := &DashboardQuery{SelectorString: values.Get("selector"),
+		Text: values.Get("q"),
+		ProblemsOnly: values.Get("problems") == "1",
+		Sort: values.Get("sort"),
+		Collapsed: make(map[string] bool)}
+
+	if _, known := dashboardSorts[q.Sort]; !known {
+		q.Sort = "name"
+	}
+	for _, group := range(values["collapse"]) {
+		q.Collapsed[group] = true
+	}
+
+	selector, err := ParseLabelSelector(q.SelectorString)
+	q.Selector = selector
+	return q, err
+}
+
+func (q *DashboardQuery) Matches(s *ServiceSnapshot) bool {
+	if !s.Matches(q.Selector) {
+		return false
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		if !strings.Contains(strings.ToLower(s.Name), text) && !strings.Contains(strings.ToLower(s.Description), text) {
+			return false
+		}
+	}
+	return true
+}
+
+// the query as url parameters, with changes applied by edit
+func (q *DashboardQuery) Values(edit func(values url.Values)) url.Values {
+	values := make(url.Values)
+	if q.SelectorString != "" {
+		values.Set("selector", q.SelectorString)
+	}
+	if q.Text != "" {
+		values.Set("q", q.Text)
+	}
+	if q.ProblemsOnly {
+		values.Set("problems", "1")
+	}
+	if q.Sort != "name" {
+		values.Set("sort", q.Sort)
+	}
+	groups := make([]string, 0, len(q.Collapsed))
+	for group := range(q.Collapsed) {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	values["collapse"] = groups
+
+	if edit != nil {
+		edit(values)
+	}
+	return values
+}
+
+func dashboardUrl(values url.Values) string {
+	if encoded := values.Encode(); encoded != "" {
+		return "/?" + encoded
+	}
+	return "/"
+}
+
+// groups the services matching q, sorted and summed up
+func buildDashboard(services []ServiceSnapshot, q *DashboardQuery) ([]*ServiceGroup, *DashboardSummary) {
+	summary := &DashboardSummary{}
+	groups := make(map[string] *ServiceGroup)
+
+	for i := range(services) {
+		s := &services[i]
+		if !q.Matches(s) {
+			continue
+		}
+
+		group, exists := groups[s.Group]
+		if !exists {
+			group = &ServiceGroup{Group: s.Group, Worst: statusName(STATUS_UP), Collapsed: q.Collapsed[s.Group]}
+			groups[s.Group] = group
+		}
+
+		switch s.Status {
+		case STATUS_UP:
+			group.Up += 1
+			summary.Up += 1
+		case STATUS_DOWN:
+			group.Down += 1
+			summary.Down += 1
+		default:
+			group.Unknown += 1
+			summary.Unknown += 1
+		}
+		if group.Down > 0 {
+			group.Worst = statusName(STATUS_DOWN)
+		} else if group.Unknown > 0 {
+			group.Worst = statusName(STATUS_UNKNOWN)
+		}
+
+		if s.HasProblem() {
+			summary.Problems += 1
+		} else if q.ProblemsOnly {
+			continue
+		}
+		group.Services = append(group.Services, s)
+	}
+
+	compare := dashboardSorts[q.Sort]
+	result := make([]*ServiceGroup, 0, len(groups))
+	for _, group := range(groups) {
+		sort.Slice(group.Services, func(i, j int) bool {
+			a, b := group.Services[i], group.Services[j]
+			if c := compare(a, b); c != 0 {
+				return c < 0
+			}
+			return a.Name < b.Name
+		})
+
+		name := group.Group
+		collapsed := group.Collapsed
+		group.ToggleUrl = dashboardUrl(q.Values(func(values url.Values) {
+			kept := make([]string, 0)
+			for _, other := range(values["collapse"]) {
+				if other != name {
+					kept = append(kept, other)
+				}
+			}
+			if !collapsed {
+				kept = append(kept, name)
+				sort.Strings(kept)
+			}
+			values["collapse"] = kept
+		}))
+
+		// with only problems shown, a group without any disappears
+		if q.ProblemsOnly && len(group.Services) == 0 {
+			continue
+		}
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Group < result[j].Group })
+
+	return result, summary
+}
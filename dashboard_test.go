@@ -0,0 +1,70 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"net/url"
+)
+
+func dashboardServices() []ServiceSnapshot {
+	warnings := []NotificationSummary{NotificationSummary{Severity: WARN, Count: 3}}
+	return []ServiceSnapshot{
+		ServiceSnapshot{Name: "web", Group: "frontend", Status: STATUS_UP, Description: "public site"},
+		ServiceSnapshot{Name: "db", Group: "infra", Status: STATUS_DOWN},
+		ServiceSnapshot{Name: "cache", Group: "infra", Status: STATUS_UP, Notifications: warnings},
+		ServiceSnapshot{Name: "api", Group: "frontend", Status: STATUS_UNKNOWN},
+		ServiceSnapshot{Name: "queue", Group: "infra", Status: STATUS_UP},
+	}
+}
+
+func dashboardNames(group *ServiceGroup) []string {
+	names := make([]string, 0)
+	for _, s := range(group.Services) {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func (s *S) TestDashboardGroups(c *C) {
+	q, err := parseDashboardQuery(url.Values{})
+	c.Assert(err, IsNil)
+
+	groups, summary := buildDashboard(dashboardServices(), q)
+	c.Assert(len(groups), Equals, 2)
+	c.Assert(groups[0].Group, Equals, "frontend")
+	c.Assert(dashboardNames(groups[0]), DeepEquals, []string{"api", "web"})
+	c.Assert(groups[0].Worst, Equals, "unknown")
+	c.Assert(dashboardNames(groups[1]), DeepEquals, []string{"cache", "db", "queue"})
+	c.Assert(groups[1].Worst, Equals, "down")
+	c.Assert(groups[1].Up, Equals, 2)
+	c.Assert(groups[1].Down, Equals, 1)
+	c.Assert(*summary, Equals, DashboardSummary{Up: 3, Down: 1, Unknown: 1, Problems: 2})
+
+	q, _ = parseDashboardQuery(url.Values{"sort": {"status"}})
+	groups, _ = buildDashboard(dashboardServices(), q)
+	c.Assert(dashboardNames(groups[1]), DeepEquals, []string{"db", "cache", "queue"})
+
+	q, _ = parseDashboardQuery(url.Values{"sort": {"events"}})
+	groups, _ = buildDashboard(dashboardServices(), q)
+	c.Assert(dashboardNames(groups[1]), DeepEquals, []string{"cache", "db", "queue"})
+}
+
+func (s *S) TestDashboardFilters(c *C) {
+	// problems only: groups keep their rollups but show just the problems
+	q, _ := parseDashboardQuery(url.Values{"problems": {"1"}})
+	groups, _ := buildDashboard(dashboardServices(), q)
+	c.Assert(len(groups), Equals, 1)
+	c.Assert(dashboardNames(groups[0]), DeepEquals, []string{"cache", "db"})
+	c.Assert(groups[0].Up, Equals, 2)
+
+	q, _ = parseDashboardQuery(url.Values{"q": {"PUBLIC"}})
+	groups, _ = buildDashboard(dashboardServices(), q)
+	c.Assert(len(groups), Equals, 1)
+	c.Assert(dashboardNames(groups[0]), DeepEquals, []string{"web"})
+
+	// collapsing is remembered in the links
+	q, _ = parseDashboardQuery(url.Values{"collapse": {"infra"}, "sort": {"status"}})
+	groups, _ = buildDashboard(dashboardServices(), q)
+	c.Assert(groups[1].Collapsed, Equals, true)
+	c.Assert(groups[1].ToggleUrl, Equals, "/?sort=status")
+	c.Assert(groups[0].ToggleUrl, Equals, "/?collapse=frontend&collapse=infra&sort=status")
+}
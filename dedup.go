@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	)
+
+// A service repeating the same message doesn't fill its log with copies.
+// Within the hub's dedup window, an entry with the same severity and
+// normalized summary (or the same explicit DedupKey) as the service's most
+// recent entry is folded into it, counting the occurrence and updating
+// LastSeen.  Repeats don't send further notifications.  Anything in between,
+// such as a recovery or a change of status, ends the run so that the next
+// failure is reported afresh.
+
+const DEFAULT_DEDUP_WINDOW = 60 * time.Second
+
+// lower case, with whitespace collapsed and numbers replaced so that eg
+// "timeout after 1503ms" and "timeout after 1498ms" are treated as the same
+func normalizeSummary(summary string) string {
+	var b strings.Builder
+	inNumber := false
+	for _, r := range(strings.ToLower(strings.Join(strings.Fields(summary), " "))) {
+		if r >= '0' && r <= '9' {
+			if !inNumber {
+				b.WriteRune('#')
+			}
+			inNumber = true
+			continue
+		}
+		inNumber = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (e *LogEntry) dedupKey() string {
+	if e.DedupKey != "" {
+		return strconv.Itoa(e.Severity)+"/key:"+e.DedupKey
+	}
+	return strconv.Itoa(e.Severity)+"/"+normalizeSummary(e.Summary)
+}
+
+// the most recent entry if entry repeats it, or nil.  statusChanged is when
+// the service's status last changed; a repeat after that isn't folded in.
+func (l *ServiceLog) findDuplicate(entry *LogEntry, window time.Duration, statusChanged time.Time) *LogEntry {
+	if window <= 0 || len(l.entries) == 0 {
+		return nil
+	}
+
+	existing := l.entries[len(l.entries) - 1]
+	if statusChanged.After(existing.LastSeen) {
+		return nil
+	}
+	if entry.Timestamp.Sub(existing.LastSeen) > window || existing.dedupKey() != entry.dedupKey() {
+		return nil
+	}
+
+	return existing
+}
+
+// folds a repeat into e, keeping the latest detail and fields
+func (e *LogEntry) addOccurrence(repeat *LogEntry) {
+	e.Count += 1
+	if repeat.Timestamp.After(e.LastSeen) {
+		e.LastSeen = repeat.Timestamp
+	}
+	if repeat.Detail != "" {
+		e.Detail = repeat.Detail
+	}
+	if repeat.Fields != nil {
+		e.Fields = repeat.Fields
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestNormalizeSummary(c *C) {
+	c.Assert(normalizeSummary("Timeout  after 1503ms"), Equals, normalizeSummary("timeout after 98ms"))
+	c.Assert(normalizeSummary("connection refused") == normalizeSummary("connection reset"), Equals, false)
+}
+
+func (s *S) TestDedupRepeatedEntries(c *C) {
+	result, tl, hub := SetupNotifier()
+	hub.dedupWindow = 60 * time.Second
+	hub.notifier.throttle = 0
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+
+	hub.Log("a", "connection refused", WARN, now)
+	hub.Log("a", "connection refused", WARN, now.Add(30 * time.Second))
+	hub.Log("a", "connection refused", WARN, now.Add(80 * time.Second))
+
+	entries := hub.services["a"].Log.entries
+	c.Assert(len(entries), Equals, 1)
+	c.Assert(entries[0].Count, Equals, 3)
+	c.Assert(entries[0].Timestamp, Equals, now)
+	c.Assert(entries[0].LastSeen, Equals, now.Add(80 * time.Second))
+
+	// only the first occurrence notified
+	c.Assert(result.String(), Matches, "[^:]*:cmd\\(a: connection refused\\)")
+
+	// a different severity, an explicit key or a gap longer than the window 
+	// starts a new entry
+	hub.Log("a", "connection refused", ERROR, now.Add(81 * time.Second))
+	hub.AddLogEntry(&LogEntry{ServiceName: "a", Summary: "connection refused", Severity: WARN, Timestamp: now.Add(82 * time.Second), DedupKey: "db"})
+	hub.Log("a", "connection refused", WARN, now.Add(200 * time.Second))
+	c.Assert(len(hub.services["a"].Log.entries), Equals, 4)
+}
+
+func (s *S) TestDedupStopsAtRecovery(c *C) {
+	result, tl, hub := SetupNotifier()
+	hub.dedupWindow = 60 * time.Second
+	hub.notifier.throttle = 0
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+
+	hub.Log("a", "connection refused", ERROR, now)
+	hub.Log("a", "connection ok", OKAY, now.Add(10 * time.Second))
+	hub.Log("a", "connection refused", ERROR, now.Add(20 * time.Second))
+
+	c.Assert(len(hub.services["a"].Log.entries), Equals, 3)
+	c.Assert(result.String(), Matches, "[^:]*:cmd\\(a: connection refused\\)[^:]*:cmd\\(a: connection refused\\)")
+	c.Assert(len(hub.incidents), Equals, 2)
+}
+
+func (s *S) TestDedupStopsAtStatusChange(c *C) {
+	result, tl, hub := SetupNotifier()
+	hub.dedupWindow = 60 * time.Second
+	hub.notifier.throttle = 0
+
+	start := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = start
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: 10 * time.Second, Enabled: true, NotificationLastMinute: 24*60})
+
+	// fails at 110, recovers at 115 and fails again at 125
+	tl.Schedule(start.Add(15 * time.Second), func() { hub.Heartbeat("a") })
+	tl.RunUntil(start.Add(30 * time.Second))
+
+	service := hub.services["a"]
+	c.Assert(service.Status, Equals, STATUS_DOWN)
+	c.Assert(len(service.Log.entries), Equals, 2)
+	c.Assert(result.String(), Matches, "[^:]*:cmd\\(a: Heartbeat failure\\)[^:]*:cmd\\(a: Heartbeat failure\\)")
+	c.Assert(len(hub.incidents), Equals, 2)
+}
+
+func (s *S) TestDedupRepeatReopensResolvedIncident(c *C) {
+	_, tl, hub := SetupNotifier()
+	hub.dedupWindow = 60 * time.Second
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+
+	hub.Log("a", "disk full", ERROR, now)
+	c.Assert(len(hub.incidents), Equals, 1)
+	for id := range(hub.incidents) {
+		c.Assert(hub.ResolveIncident(id, "bob"), IsNil)
+	}
+
+	hub.Log("a", "disk full", ERROR, now.Add(10 * time.Second))
+	c.Assert(len(hub.services["a"].Log.entries), Equals, 1)
+	c.Assert(hub.services["a"].Log.entries[0].Count, Equals, 2)
+	c.Assert(len(hub.incidents), Equals, 2)
+	c.Assert(hub.openIncident("a"), NotNil)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	)
+
+// Services may declare the services they depend on.  When an upstream
+// service is down, notifications from the services below it are collapsed
+// into a single message about the upstream failure rather than sent
+// individually.
+
+// returns the names making up a dependency cycle, or nil if there isn't
+// one.  dependencies maps each service to the services it depends on.
+func findDependencyCycle(dependencies map[string] []string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make(map[string] int)
+	path := make([]string, 0, len(dependencies))
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			// the cycle is the part of the path starting at name
+			for i, v := range(path) {
+				if v == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case done:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, upstream := range(dependencies[name]) {
+			if cycle := visit(upstream); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+
+		return nil
+	}
+
+	// visit in a fixed order so the same config always reports the same cycle
+	names := make([]string, 0, len(dependencies))
+	for name, _ := range(dependencies) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range(names) {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+func describeCycle(cycle []string) string {
+	return "dependency cycle: "+strings.Join(cycle, " -> ")
+}
+
+// the dependency graph of the hub's services, with config (if non-nil)
+// replacing the current settings for that service
+func (h *ServiceHub) dependencyGraph(config *ServiceConfig) map[string] []string {
+	dependencies := make(map[string] []string)
+	for name, s := range(h.services) {
+		dependencies[name] = s.DependsOn
+	}
+	if config != nil {
+		dependencies[config.Name] = config.DependsOn
+	}
+	return dependencies
+}
+
+// checks that giving a service config wouldn't introduce a cycle
+func (h *ServiceHub) checkDependencies(config *ServiceConfig) *ApiError {
+	if cycle := findDependencyCycle(h.dependencyGraph(config)); cycle != nil {
+		return &ApiError{describeCycle(cycle)}
+	}
+	return nil
+}
+
+// the services upstream of s which are down and are not themselves
+// explained by a failure further upstream
+func (h *ServiceHub) rootCauses(s *Service) []string {
+	roots := make(map[string] bool)
+
+	// whether a service at or above name is down
+	memo := make(map[string] bool)
+	var downAtOrAbove func(name string) bool
+	downAtOrAbove = func(name string) bool {
+		if result, seen := memo[name]; seen {
+			return result
+		}
+		// guards against cycles while we recurse
+		memo[name] = false
+
+		upstream, exists := h.services[name]
+		if !exists {
+			return false
+		}
+
+		upstreamDown := false
+		for _, next := range(upstream.DependsOn) {
+			if downAtOrAbove(next) {
+				upstreamDown = true
+			}
+		}
+
+		if !upstreamDown && upstream.Status == STATUS_DOWN {
+			roots[name] = true
+		}
+
+		memo[name] = upstreamDown || upstream.Status == STATUS_DOWN
+		return memo[name]
+	}
+
+	for _, name := range(s.DependsOn) {
+		downAtOrAbove(name)
+	}
+
+	result := make([]string, 0, len(roots))
+	for name, _ := range(roots) {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestFindDependencyCycle(c *C) {
+	c.Assert(findDependencyCycle(map[string] []string{
+		"web": []string{"db", "cache"},
+		"cache": []string{"db"},
+		"db": nil}), IsNil)
+
+	cycle := findDependencyCycle(map[string] []string{
+		"a": []string{"b"},
+		"b": []string{"c"},
+		"c": []string{"a"}})
+	c.Assert(cycle, DeepEquals, []string{"a", "b", "c", "a"})
+}
+
+func (s *S) TestRootCauses(c *C) {
+	hub := NewServiceHub(NewTimeline(new(SimulatedTimer)))
+
+	hub.AddService(&ServiceConfig{Name: "db", Timeout: time.Hour})
+	hub.AddService(&ServiceConfig{Name: "cache", Timeout: time.Hour, DependsOn: []string{"db"}})
+	hub.AddService(&ServiceConfig{Name: "web", Timeout: time.Hour, DependsOn: []string{"cache", "db"}})
+
+	c.Assert(len(hub.rootCauses(hub.services["web"])), Equals, 0)
+
+	hub.services["cache"].Status = STATUS_DOWN
+	c.Assert(hub.rootCauses(hub.services["web"]), DeepEquals, []string{"cache"})
+
+	// the db being down explains the cache being down
+	hub.services["db"].Status = STATUS_DOWN
+	c.Assert(hub.rootCauses(hub.services["web"]), DeepEquals, []string{"db"})
+	c.Assert(hub.rootCauses(hub.services["cache"]), DeepEquals, []string{"db"})
+	c.Assert(len(hub.rootCauses(hub.services["db"])), Equals, 0)
+}
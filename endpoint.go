@@ -5,6 +5,9 @@ import (
 //	"log"
 	"encoding/json"
 	"io"
+	"net/url"
+	"strconv"
+	"time"
 	)
 
 type Sample struct { }
@@ -15,8 +18,14 @@ type JsonRpcCallback func (map[string] interface{}) interface{}
 
 type JsonRpcHandler struct {
 	registry map[string] JsonRpcCallback
+	// nil unless UseTokens was called.  See tokens.go.
+	tokens *TokenStore
+	hub ThreadSafeServiceHub
 }
 
+// whether a call may go ahead
+type JsonRpcCheck func (method string, params map[string] interface{}) *ApiError
+
 
 func (j *JsonRpcHandler) Register(name string, fn JsonRpcCallback) {
 	if j.registry == nil {
@@ -27,6 +36,10 @@ func (j *JsonRpcHandler) Register(name string, fn JsonRpcCallback) {
 }
 
 func (j *JsonRpcHandler) ExecuteJsonPayload(request map[string] interface{}) map[string] interface{} {
+	return j.executeJsonPayload(request, nil)
+}
+
+func (j *JsonRpcHandler) executeJsonPayload(request map[string] interface{}, check JsonRpcCheck) map[string] interface{} {
 	method := request["method"]
 	methodStr := method.(string)
 
@@ -34,6 +47,14 @@ func (j *JsonRpcHandler) ExecuteJsonPayload(request map[string] interface{}) map
 
 	params := request["params"].(map[string] interface{})
 
+	if check != nil {
+		if err := check(methodStr, params); err != nil {
+			result := makeJsonRpcError(403, err.String())
+			result["id"] = requestId
+			return result
+		}
+	}
+
 	methodValue := j.registry[methodStr]
 	methodResult := methodValue(params)
 
@@ -45,6 +66,30 @@ func (j *JsonRpcHandler) ExecuteJsonPayload(request map[string] interface{}) map
 	return result
 }
 
+// params as form values, so they can share parsing with the web pages
+func rpcValues(params map[string] interface{}) url.Values {
+	values := make(url.Values)
+	var add func(key string, value interface{})
+	add = func(key string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			values.Add(key, v)
+		case float64:
+			values.Add(key, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			values.Add(key, strconv.FormatBool(v))
+		case []interface{}:
+			for _, item := range(v) {
+				add(key, item)
+			}
+		}
+	}
+	for key, value := range(params) {
+		add(key, value)
+	}
+	return values
+}
+
 func makeJsonRpcError(code int, msg string) map[string] interface{} {
 	error := make(map[string] interface{})
 	
@@ -62,6 +107,10 @@ func makeJsonRpcError(code int, msg string) map[string] interface{} {
 type SetStatusCodeFn func (code int) 
 
 func (j *JsonRpcHandler) ExecuteJson(request io.Reader, response io.Writer, setStatus SetStatusCodeFn) {
+	j.executeJson(request, response, setStatus, nil)
+}
+
+func (j *JsonRpcHandler) executeJson(request io.Reader, response io.Writer, setStatus SetStatusCodeFn, check JsonRpcCheck) {
 	d := json.NewDecoder(request)
 
 	var requestMap map[string] interface{}
@@ -69,7 +118,7 @@ func (j *JsonRpcHandler) ExecuteJson(request io.Reader, response io.Writer, setS
 	
 	err := d.Decode(&requestMap)
 	if err == nil {
-		responseObj = j.ExecuteJsonPayload(requestMap)
+		responseObj = j.executeJsonPayload(requestMap, check)
 	} else {
 		responseObj = makeJsonRpcError(-32700, "Parse error")
 	}
@@ -87,15 +136,159 @@ func (j JsonRpcHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		io.WriteString(w, "405 Only POST Allowed\n")
 		return 
 	}
+
+	token, err := j.tokens.Authenticate(req)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(makeJsonRpcError(401, err.String()))
+		return
+	}
+
+	var check JsonRpcCheck
+	if j.tokens != nil {
+		// without a token, a signed in user may make changes.  Without
+		// auth, that's everyone.  A browser on another site is nobody,
+		// whatever cookies or basic auth it sends.
+		var user *User
+		if token == nil && !crossSite(req) {
+			user = j.tokens.auth.User(req)
+		}
+		check = func(method string, params map[string] interface{}) *ApiError {
+			return j.tokens.Authorize(token, user, method, params, req.RemoteAddr, &j)
+		}
+	}
 	
-	j.ExecuteJson(req.Body, w, func(status int) { 
+	j.executeJson(req.Body, w, func(status int) { 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(status)
-	})
+	}, check)
 
 	return
 }
 
+func (j *JsonRpcHandler) serviceGroup(serviceName string) (string, bool) {
+	if j.hub == nil {
+		return "", false
+	}
+	return j.hub.GetServiceGroup(serviceName)
+}
+
+func (j *JsonRpcHandler) incidentService(id int) (string, bool) {
+	if j.hub == nil {
+		return "", false
+	}
+	incident := j.hub.GetIncident(id)
+	if incident == nil {
+		return "", false
+	}
+	return incident.Service, true
+}
+
+func (j *JsonRpcHandler) silenceSelector(id int) (LabelSelector, bool) {
+	if j.hub == nil {
+		return nil, false
+	}
+	for _, silence := range(j.hub.GetSilences()) {
+		if silence.Id == id {
+			selector, err := ParseLabelSelector(silence.Selector)
+			return selector, err == nil
+		}
+	}
+	return nil, false
+}
+
+// applies any service settings present in params to config
+func applyServiceParams(params map[string] interface{}, config *ServiceConfig, severities *SeverityTable) *ApiError {
+	for key, value := range(params) {
+		switch key {
+		case "name":
+			// identifies the service, so it can't be changed here
+		case "timeout":
+			seconds, ok := value.(float64)
+			if !ok || seconds <= 0 {
+				return &ApiError{"timeout must be a positive number of seconds"}
+			}
+			config.Timeout = time.Duration(seconds * float64(time.Second))
+		case "enabled":
+			enabled, ok := value.(bool)
+			if !ok {
+				return &ApiError{"enabled must be true or false"}
+			}
+			config.Enabled = enabled
+		case "notify_severity":
+			severity, err := severities.ParseValue(value)
+			if err != nil {
+				return &ApiError{"notify_severity: "+err.Error()}
+			}
+			config.NotifySeverity = &severity
+		case "depends_on":
+			list, ok := value.([]interface{})
+			if !ok {
+				return &ApiError{"depends_on must be a list of service names"}
+			}
+			dependsOn := make([]string, 0, len(list))
+			for _, item := range(list) {
+				name, ok := item.(string)
+				if !ok {
+					return &ApiError{"depends_on must be a list of service names"}
+				}
+				dependsOn = append(dependsOn, name)
+			}
+			config.DependsOn = dependsOn
+		case "labels":
+			object, ok := value.(map[string] interface{})
+			if !ok {
+				return &ApiError{"labels must be an object of strings"}
+			}
+			labels := make(map[string] string)
+			for k, v := range(object) {
+				str, ok := v.(string)
+				if !ok || !isValidLabelKey(k) {
+					return &ApiError{"labels must be an object of strings"}
+				}
+				labels[k] = str
+			}
+			config.Labels = labels
+		case "group", "description", "link", "notifications_start", "notifications_stop", "ping_token":
+			str, ok := value.(string)
+			if !ok {
+				return &ApiError{key+" must be a string"}
+			}
+
+			switch key {
+			case "group":
+				config.Group = str
+			case "description":
+				config.Description = str
+			case "link":
+				config.Link = str
+			case "ping_token":
+				config.PingToken = str
+			default:
+				minute, err := parseTimeOfDay(str)
+				if err != nil {
+					return &ApiError{key+": "+err.Error()}
+				}
+				if key == "notifications_start" {
+					config.NotificationFirstMinute = minute
+				} else {
+					config.NotificationLastMinute = minute
+				}
+			}
+		default:
+			return &ApiError{"Unknown parameter \""+key+"\""}
+		}
+	}
+
+	if config.NotificationFirstMinute > config.NotificationLastMinute {
+		return &ApiError{"notifications_start must not be after notifications_stop"}
+	}
+
+	return nil
+}
+
 func MewJsonRpcHandler (hub ThreadSafeServiceHub, timeline *Timeline) *JsonRpcHandler{
 
 	r := new(JsonRpcHandler)
@@ -117,26 +310,263 @@ func MewJsonRpcHandler (hub ThreadSafeServiceHub, timeline *Timeline) *JsonRpcHa
 		name := params["name"].(string)
 		summary := params["summary"].(string)
 
-		var severity int
-		s := params["severity"]
-		
-		if _, ok := s.(float64) ; ok {
-			severity = int(s.(float64))
-		} else {
-			severity = s.(int)
+		// by name or number
+		severity, err := hub.GetSeverities().ParseValue(params["severity"])
+		if err != nil {
+			return makeJsonRpcError(100, err.Error())
 		}
 
-// else {
-//			severity = strconv.Atoi(s.(string))
-//		}
+		entry := &LogEntry{ServiceName: name, Summary: summary, Severity: severity, Timestamp: timeline.Now()}
 
-		err := hub.Log(name, summary, severity, timeline.Now())
-		
-		if err == nil {
-			return true
+		if detail, exists := params["detail"]; exists {
+			text, ok := detail.(string)
+			if !ok {
+				return makeJsonRpcError(100, "detail must be a string")
+			}
+			entry.Detail = text
 		}
 
-		return makeJsonRpcError(100, err.String())
+		if key, exists := params["dedup_key"]; exists {
+			text, ok := key.(string)
+			if !ok {
+				return makeJsonRpcError(100, "dedup_key must be a string")
+			}
+			entry.DedupKey = text
+		}
+
+		if fields, exists := params["fields"]; exists {
+			m, ok := fields.(map[string] interface{})
+			if !ok {
+				return makeJsonRpcError(100, "fields must be an object")
+			}
+			entry.Fields = make(map[string] string, len(m))
+			for k, v := range(m) {
+				switch value := v.(type) {
+				case string:
+					entry.Fields[k] = value
+				case nil:
+					entry.Fields[k] = ""
+				default:
+					// numbers, booleans and nested values are kept as JSON
+					b, _ := json.Marshal(value)
+					entry.Fields[k] = string(b)
+				}
+			}
+		}
+
+		if err := hub.AddLogEntry(entry); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+
+		return true
+	})
+
+	r.Register("register_service", func(params map[string] interface{}) interface{} {
+		name, ok := params["name"].(string)
+		if !ok || name == "" {
+			return makeJsonRpcError(100, "name is required")
+		}
+
+		if _, ok := params["timeout"]; !ok {
+			return makeJsonRpcError(100, "timeout is required")
+		}
+
+		config := defaultServiceConfig(name)
+		if err := applyServiceParams(params, config, hub.GetSeverities()); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+
+		if err := hub.RegisterService(config); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+
+		return true
+	})
+
+	r.Register("update_service", func(params map[string] interface{}) interface{} {
+		name, ok := params["name"].(string)
+		if !ok {
+			return makeJsonRpcError(100, "name is required")
+		}
+
+		// looked up first since edit runs on the hub's thread
+		severities := hub.GetSeverities()
+		err := hub.UpdateService(name, func(config *ServiceConfig) *ApiError {
+			return applyServiceParams(params, config, severities)
+		})
+
+		if err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+
+		return true
+	})
+
+	r.Register("deregister_service", func(params map[string] interface{}) interface{} {
+		name, ok := params["name"].(string)
+		if !ok {
+			return makeJsonRpcError(100, "name is required")
+		}
+
+		if err := hub.DeregisterService(name); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+
+		return true
+	})
+
+	r.Register("get_services", func(params map[string] interface{}) interface{} {
+		selectorString, _ := params["selector"].(string)
+		selector, err := ParseLabelSelector(selectorString)
+		if err != nil {
+			return makeJsonRpcError(100, err.Error())
+		}
+
+		scope, _ := params["scope"].(*ApiToken)
+		result := make([]map[string] interface{}, 0, 100)
+		for _, s := range(hub.GetServices()) {
+			if !s.Matches(selector) || !scope.covers(s.Name, s.Group) {
+				continue
+			}
+			result = append(result, map[string] interface{}{"name": s.Name,
+				"group": s.Group,
+				"status": s.Status,
+				"enabled": s.Enabled,
+				"description": s.Description,
+				"labels": s.Labels})
+		}
+
+		return result
+	})
+
+	r.Register("add_silence", func(params map[string] interface{}) interface{} {
+		selectorString, _ := params["selector"].(string)
+		selector, err := ParseLabelSelector(selectorString)
+		if err != nil {
+			return makeJsonRpcError(100, err.Error())
+		}
+		if len(selector) == 0 {
+			return makeJsonRpcError(100, "selector is required")
+		}
+
+		seconds, ok := params["duration"].(float64)
+		if !ok || seconds <= 0 {
+			return makeJsonRpcError(100, "duration must be a positive number of seconds")
+		}
+
+		comment, _ := params["comment"].(string)
+
+		return hub.AddSilence(selector, time.Duration(seconds * float64(time.Second)), comment)
+	})
+
+	r.Register("remove_silence", func(params map[string] interface{}) interface{} {
+		id, ok := params["id"].(float64)
+		if !ok {
+			return makeJsonRpcError(100, "id is required")
+		}
+
+		if err := hub.RemoveSilence(int(id)); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+
+		return true
+	})
+
+	r.Register("list_silences", func(params map[string] interface{}) interface{} {
+		return hub.GetSilences()
+	})
+
+	// takes the same parameters as /list-events-data; service and group
+	// may be lists
+	r.Register("list_events", func(params map[string] interface{}) interface{} {
+		severities := hub.GetSeverities()
+		q, err := parseEventQuery(rpcValues(params), severities)
+		if err != nil {
+			return makeJsonRpcError(100, err.Error())
+		}
+		q.Scope, _ = params["scope"].(*ApiToken)
+
+		page, apiErr := hub.SearchLog(q)
+		if apiErr != nil {
+			return makeJsonRpcError(100, apiErr.String())
+		}
+		return eventPageResult(q, page, severities)
+	})
+
+	r.Register("list_incidents", func(params map[string] interface{}) interface{} {
+		state, _ := params["state"].(string)
+		scope, _ := params["scope"].(*ApiToken)
+
+		result := make([]*IncidentSnapshot, 0)
+		for _, incident := range(hub.GetIncidents()) {
+			if state != "" && incident.State != state {
+				continue
+			}
+			if scope != nil {
+				group, _ := hub.GetServiceGroup(incident.Service)
+				if !scope.covers(incident.Service, group) {
+					continue
+				}
+			}
+			result = append(result, incident)
+		}
+		return result
+	})
+
+	r.Register("get_incident", func(params map[string] interface{}) interface{} {
+		id, ok := params["id"].(float64)
+		if !ok {
+			return makeJsonRpcError(100, "id is required")
+		}
+
+		incident := hub.GetIncident(int(id))
+		if incident == nil {
+			return makeJsonRpcError(100, "No incident with that id")
+		}
+		return incident
+	})
+
+	r.Register("acknowledge_incident", func(params map[string] interface{}) interface{} {
+		id, ok := params["id"].(float64)
+		if !ok {
+			return makeJsonRpcError(100, "id is required")
+		}
+		by, _ := params["by"].(string)
+
+		if err := hub.AcknowledgeIncident(int(id), by); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+		return true
+	})
+
+	r.Register("resolve_incident", func(params map[string] interface{}) interface{} {
+		id, ok := params["id"].(float64)
+		if !ok {
+			return makeJsonRpcError(100, "id is required")
+		}
+		by, _ := params["by"].(string)
+
+		if err := hub.ResolveIncident(int(id), by); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+		return true
+	})
+
+	r.Register("assign_incident", func(params map[string] interface{}) interface{} {
+		id, ok := params["id"].(float64)
+		if !ok {
+			return makeJsonRpcError(100, "id is required")
+		}
+		assignee, ok := params["assignee"].(string)
+		if !ok {
+			return makeJsonRpcError(100, "assignee is required")
+		}
+		by, _ := params["by"].(string)
+
+		if err := hub.AssignIncident(int(id), assignee, by); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+		return true
 	})
 
 	return r	
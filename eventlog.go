@@ -0,0 +1,452 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	)
+
+// Searching the log entries of every service.  A query filters on service,
+// group, a severity range, a time range and the text of the summary, sorts
+// on any column and returns a page of entries at a time.
+//
+// Pages are chained by cursor rather than offset: a page's cursor is the
+// sequence number of its last entry, and the next page starts after that
+// entry.  Sorted by id, that's a comparison of sequence numbers, so paging
+// is unaffected by entries being added or removed.  Sorted by another
+// column, the page starts after the cursor entry's place in the order,
+// with the sequence number breaking ties.
+//
+// Searches run on the hub's timeline, so their cost is bounded: a page
+// looks at no more than MAX_EVENT_SCAN entries.  Sorted by id, the entries
+// of each service are already in order, so a page walks them from the
+// cursor on.  Sorted by another column, only the newest MAX_EVENT_SCAN
+// entries are searched.  Either way the page's Total then counts only what
+// was looked at, and Capped is set.  Sorted by id, a capped page may hold
+// fewer entries than asked for, or none, but its cursor leads on to the
+// rest.
+
+const (
+	DEFAULT_EVENT_PAGE = 50
+	MAX_EVENT_PAGE = 1000
+	MAX_EVENT_SCAN = 50000
+	MAX_EVENT_REGEXP = 200
+	)
+
+var eventSortColumns = map[string] func(a, b *LogEntry) int{
+	"id": func(a, b *LogEntry) int { return compareInts(a.Sequence, b.Sequence) },
+	"service": func(a, b *LogEntry) int { return strings.Compare(a.ServiceName, b.ServiceName) },
+	"severity": func(a, b *LogEntry) int { return compareInts(a.Severity, b.Severity) },
+	"summary": func(a, b *LogEntry) int { return strings.Compare(a.Summary, b.Summary) },
+	"count": func(a, b *LogEntry) int { return compareInts(a.Count, b.Count) },
+	"timestamp": func(a, b *LogEntry) int { return a.Timestamp.Compare(b.Timestamp) },
+	"last_seen": func(a, b *LogEntry) int { return a.LastSeen.Compare(b.LastSeen) },
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+type EventQuery struct {
+	// empty matches every service or group
+	Services []string
+	Groups []string
+	MinSeverity int
+	// -1 for no maximum
+	MaxSeverity int
+	// entries seen at some point in [Since, Until); zero times are open
+	Since time.Time
+	Until time.Time
+	// case-insensitive, in the summary or detail
+	Text string
+	// on the summary
+	Expression *regexp.Regexp
+	// the token searching, which limits it to its services, or nil
+	Scope *ApiToken
+
+	Sort string
+	Descending bool
+	// the sequence number of the last entry of the previous page, or 0
+	After int
+	Limit int
+}
+
+type EventPage struct {
+	Entries []*LogEntry
+	// how many entries match, from this page on
+	Total int
+	// the search stopped at MAX_EVENT_SCAN entries, so Total is a lower
+	// bound
+	Capped bool
+	// the cursor for the next page, or 0 if this is the last
+	Next int
+}
+
+// parses a query from form values: service and group (repeatable),
+// min_severity, max_severity, since, until, q, regexp, sort, dir, after
+// and results
+func parseEventQuery(values url.Values, severities *SeverityTable) (*EventQuery, error) {
+	q := &EventQuery{MaxSeverity: -1, Sort: "id", Limit: DEFAULT_EVENT_PAGE}
+
+	for _, name := range(values["service"]) {
+		if name != "" {
+			q.Services = append(q.Services, name)
+		}
+	}
+	for _, name := range(values["group"]) {
+		if name != "" {
+			q.Groups = append(q.Groups, name)
+		}
+	}
+
+	var err error
+	if s := values.Get("min_severity"); s != "" {
+		if q.MinSeverity, err = severities.Parse(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := values.Get("max_severity"); s != "" {
+		if q.MaxSeverity, err = severities.Parse(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s := values.Get("since"); s != "" {
+		if q.Since, err = parseQueryTime(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := values.Get("until"); s != "" {
+		if q.Until, err = parseQueryTime(s); err != nil {
+			return nil, err
+		}
+	}
+
+	q.Text = values.Get("q")
+	if s := values.Get("regexp"); s != "" {
+		if len(s) > MAX_EVENT_REGEXP {
+			return nil, fmt.Errorf("regexp must be at most %d characters", MAX_EVENT_REGEXP)
+		}
+		if q.Expression, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s := values.Get("sort"); s != "" {
+		if _, exists := eventSortColumns[s]; !exists {
+			return nil, fmt.Errorf("can't sort on \"%s\"", s)
+		}
+		q.Sort = s
+	}
+	switch values.Get("dir") {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		return nil, fmt.Errorf("dir must be asc or desc")
+	}
+
+	if s := values.Get("after"); s != "" {
+		if q.After, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("after must be an event id")
+		}
+	}
+	if s := values.Get("results"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 {
+			return nil, fmt.Errorf("results must be a positive number")
+		}
+		if q.Limit > MAX_EVENT_PAGE {
+			q.Limit = MAX_EVENT_PAGE
+		}
+	}
+
+	return q, nil
+}
+
+// RFC 3339, a unix time, or a local date and time as sent by a datetime-local
+// input
+func parseQueryTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range([]string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}) {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't read \"%s\" as a time", s)
+}
+
+func (q *EventQuery) Matches(entry *LogEntry, group string) bool {
+	if len(q.Services) > 0 && !containsString(q.Services, entry.ServiceName) {
+		return false
+	}
+	if len(q.Groups) > 0 && !containsString(q.Groups, group) {
+		return false
+	}
+	if !q.Scope.covers(entry.ServiceName, group) {
+		return false
+	}
+	if entry.Severity < q.MinSeverity || (q.MaxSeverity >= 0 && entry.Severity > q.MaxSeverity) {
+		return false
+	}
+	if !q.Since.IsZero() && entry.LastSeen.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Timestamp.Before(q.Until) {
+		return false
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		if !strings.Contains(strings.ToLower(entry.Summary), text) && !strings.Contains(strings.ToLower(entry.Detail), text) {
+			return false
+		}
+	}
+	if q.Expression != nil && !q.Expression.MatchString(entry.Summary) {
+		return false
+	}
+	return true
+}
+
+// whether a comes before b in the query's order
+func (q *EventQuery) less(a, b *LogEntry) bool {
+	c := eventSortColumns[q.Sort](a, b)
+	if c == 0 {
+		c = compareInts(a.Sequence, b.Sequence)
+	}
+	if q.Descending {
+		return c > 0
+	}
+	return c < 0
+}
+
+// a service's entries, which are in id order, walked from next onwards
+type logRun struct {
+	entries []*LogEntry
+	group string
+	next int
+}
+
+// the runs of several services merged into a single walk in id order
+type logRuns struct {
+	runs []*logRun
+	descending bool
+}
+
+func (r *logRuns) Len() int { return len(r.runs) }
+func (r *logRuns) Swap(i, j int) { r.runs[i], r.runs[j] = r.runs[j], r.runs[i] }
+func (r *logRuns) Push(x interface{}) { r.runs = append(r.runs, x.(*logRun)) }
+
+func (r *logRuns) Less(i, j int) bool {
+	a := r.runs[i].entries[r.runs[i].next].Sequence
+	b := r.runs[j].entries[r.runs[j].next].Sequence
+	if r.descending {
+		return a > b
+	}
+	return a < b
+}
+
+func (r *logRuns) Pop() interface{} {
+	run := r.runs[len(r.runs) - 1]
+	r.runs = r.runs[:len(r.runs) - 1]
+	return run
+}
+
+// calls visit on the entries of services in id order, starting after the
+// entry with sequence number after (or at the start if it's 0).  capped is
+// true if it stopped early because MAX_EVENT_SCAN entries had been looked
+// at, and last is then the sequence number of the last of them.
+func walkLog(services []*Service, descending bool, after int, visit func(entry *LogEntry, group string)) (last int, capped bool) {
+	runs := &logRuns{descending: descending}
+	for _, service := range(services) {
+		entries := service.Log.entries
+		var next int
+		if !descending {
+			next = sort.Search(len(entries), func(i int) bool { return entries[i].Sequence > after })
+		} else if after == 0 {
+			next = len(entries) - 1
+		} else {
+			next = sort.Search(len(entries), func(i int) bool { return entries[i].Sequence >= after }) - 1
+		}
+		if next >= 0 && next < len(entries) {
+			runs.runs = append(runs.runs, &logRun{entries, service.Group, next})
+		}
+	}
+	heap.Init(runs)
+
+	for scanned := 0; runs.Len() > 0; scanned++ {
+		if scanned == MAX_EVENT_SCAN {
+			return last, true
+		}
+
+		run := runs.runs[0]
+		entry := run.entries[run.next]
+		if descending {
+			run.next -= 1
+		} else {
+			run.next += 1
+		}
+		if run.next < 0 || run.next >= len(run.entries) {
+			heap.Pop(runs)
+		} else {
+			heap.Fix(runs, 0)
+		}
+
+		visit(entry, run.group)
+		last = entry.Sequence
+	}
+	return last, false
+}
+
+func (h *ServiceHub) searchLog(q *EventQuery) (*EventPage, *ApiError) {
+	services := make([]*Service, 0, len(h.services))
+	for _, service := range(h.services) {
+		if len(q.Services) == 0 || containsString(q.Services, service.Name) {
+			services = append(services, service)
+		}
+	}
+
+	if q.Sort == "id" {
+		// the walk is already in order, so the page is the first matches
+		// after the cursor, whether or not the cursor entry still exists
+		page := &EventPage{}
+		var last *LogEntry
+		scanned, capped := walkLog(services, q.Descending, q.After, func(entry *LogEntry, group string) {
+			if q.Matches(entry, group) {
+				if len(page.Entries) < q.Limit {
+					e := *entry
+					page.Entries = append(page.Entries, &e)
+					last = entry
+				} else if page.Next == 0 {
+					page.Next = last.Sequence
+				}
+				page.Total += 1
+			}
+		})
+
+		// a page cut short by the cap carries on from where the scan
+		// stopped, even if it has no entries
+		page.Capped = capped
+		if capped && page.Next == 0 {
+			if len(page.Entries) == q.Limit {
+				page.Next = last.Sequence
+			} else {
+				page.Next = scanned
+			}
+		}
+		return page, nil
+	}
+
+	// sorting on another column needs every match, so only the newest
+	// entries are searched
+	matches := make([]*LogEntry, 0)
+	_, capped := walkLog(services, true, 0, func(entry *LogEntry, group string) {
+		if q.Matches(entry, group) {
+			matches = append(matches, entry)
+		}
+	})
+
+	sort.Slice(matches, func(i, j int) bool { return q.less(matches[i], matches[j]) })
+
+	start := 0
+	if q.After != 0 {
+		var cursor *LogEntry
+		for _, entry := range(matches) {
+			if entry.Sequence == q.After {
+				cursor = entry
+				break
+			}
+		}
+		if cursor == nil {
+			return nil, &ApiError{"Event "+strconv.Itoa(q.After)+" no longer matches; start again from the first page"}
+		}
+		start = sort.Search(len(matches), func(i int) bool { return q.less(cursor, matches[i]) })
+	}
+
+	end := start + q.Limit
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	page := &EventPage{Total: len(matches) - start, Capped: capped}
+	for _, entry := range(matches[start:end]) {
+		e := *entry
+		page.Entries = append(page.Entries, &e)
+	}
+	if end < len(matches) {
+		page.Next = matches[end - 1].Sequence
+	}
+	return page, nil
+}
+
+func (a *ServiceHubAdapter) SearchLog(q *EventQuery) (*EventPage, *ApiError) {
+	type result struct {
+		page *EventPage
+		err *ApiError
+	}
+	c := make(chan result)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		page, err := hub.searchLog(q)
+		c <- result{page, err}
+	})
+
+	r := <-c
+	return r.page, r.err
+}
+
+// an entry as the event pages and the list_events rpc show it
+func eventRecord(l *LogEntry, severities *SeverityTable) map[string] interface{} {
+	return map[string] interface{}{
+		"id": l.Sequence,
+		"service": l.ServiceName,
+		"summary": l.Summary,
+		"severity": l.Severity,
+		"severity_name": severities.Name(l.Severity),
+		"timestamp": l.Timestamp,
+		"count": l.Count,
+		"last_seen": l.LastSeen,
+		"detail": l.Detail,
+		"fields": l.Fields,
+		}
+}
+
+func eventPageResult(q *EventQuery, page *EventPage, severities *SeverityTable) map[string] interface{} {
+	records := make([]map[string] interface{}, 0, len(page.Entries))
+	for _, l := range(page.Entries) {
+		records = append(records, eventRecord(l, severities))
+	}
+
+	dir := "asc"
+	if q.Descending {
+		dir = "desc"
+	}
+
+	var next interface{}
+	if page.Next != 0 {
+		next = page.Next
+	}
+
+	return map[string] interface{}{"recordsReturned": len(records),
+		"totalRecords": page.Total,
+		"totalCapped": page.Capped,
+		"sort": q.Sort,
+		"dir": dir,
+		"pageSize": q.Limit,
+		"next": next,
+		"records": records }
+}
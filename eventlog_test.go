@@ -0,0 +1,162 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func setupEventLog() *ServiceHub {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(1000, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "db", Group: "infra", Timeout: time.Hour, NotificationLastMinute: 24*60})
+	hub.AddService(&ServiceConfig{Name: "web", Group: "frontend", Timeout: time.Hour, NotificationLastMinute: 24*60})
+
+	hub.AddLogEntry(&LogEntry{ServiceName: "db", Summary: "replica lagging", Severity: WARN, Timestamp: now})
+	hub.AddLogEntry(&LogEntry{ServiceName: "web", Summary: "request timeout", Severity: ERROR, Timestamp: now.Add(time.Minute)})
+	hub.AddLogEntry(&LogEntry{ServiceName: "db", Summary: "backup done", Severity: INFO, Timestamp: now.Add(2 * time.Minute)})
+	hub.AddLogEntry(&LogEntry{ServiceName: "web", Summary: "deploy", Severity: INFO, Timestamp: now.Add(3 * time.Minute), Detail: "Replica set updated"})
+	hub.AddLogEntry(&LogEntry{ServiceName: "db", Summary: "disk full", Severity: CRITICAL, Timestamp: now.Add(4 * time.Minute)})
+
+	return hub
+}
+
+func (s *S) TestSearchLogFilters(c *C) {
+	hub := setupEventLog()
+
+	count := func(query string) int {
+		values, _ := url.ParseQuery(query)
+		q, err := parseEventQuery(values, hub.severities)
+		c.Assert(err, IsNil)
+		page, _ := hub.searchLog(q)
+		return page.Total
+	}
+
+	c.Assert(count(""), Equals, 5)
+	c.Assert(count("service=db"), Equals, 3)
+	c.Assert(count("service=db&service=web"), Equals, 5)
+	c.Assert(count("group=frontend"), Equals, 2)
+	c.Assert(count("min_severity=warn"), Equals, 3)
+	c.Assert(count("min_severity=warn&max_severity=error"), Equals, 2)
+	c.Assert(count("since=1060&until=1180"), Equals, 2)
+	// the text matches the summary or detail, the regexp only the summary
+	c.Assert(count("q=REPLICA"), Equals, 2)
+	c.Assert(count("regexp=^re"), Equals, 2)
+	c.Assert(count("regexp=Replica"), Equals, 0)
+
+	for _, query := range([]string{"sort=color", "dir=up", "min_severity=loud", "regexp=(", "since=yesterday", "results=0"}) {
+		values, _ := url.ParseQuery(query)
+		_, err := parseEventQuery(values, hub.severities)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *S) TestSearchLogSortAndCursor(c *C) {
+	hub := setupEventLog()
+
+	values := url.Values{"sort": {"severity"}, "dir": {"desc"}, "results": {"2"}}
+	q, _ := parseEventQuery(values, hub.severities)
+	page, _ := hub.searchLog(q)
+	c.Assert(len(page.Entries), Equals, 2)
+	c.Assert(page.Entries[0].Summary, Equals, "disk full")
+	c.Assert(page.Entries[1].Summary, Equals, "request timeout")
+
+	// equal severities are ordered by id
+	q.After = page.Next
+	page, _ = hub.searchLog(q)
+	c.Assert(page.Entries[0].Summary, Equals, "replica lagging")
+	c.Assert(page.Entries[1].Summary, Equals, "deploy")
+
+	q.After = page.Next
+	page, _ = hub.searchLog(q)
+	c.Assert(len(page.Entries), Equals, 1)
+	c.Assert(page.Entries[0].Summary, Equals, "backup done")
+	c.Assert(page.Next, Equals, 0)
+
+	// by id, paging survives the cursor entry being removed
+	q, _ = parseEventQuery(url.Values{"results": {"2"}}, hub.severities)
+	page, _ = hub.searchLog(q)
+	c.Assert(page.Next, Equals, page.Entries[1].Sequence)
+	cursor := page.Next
+	for _, service := range(hub.services) {
+		service.Log.entries = removeLogEntriesWithId(service.Log.entries, cursor)
+	}
+	q.After = cursor
+	page, _ = hub.searchLog(q)
+	c.Assert(page.Entries[0].Summary, Equals, "backup done")
+
+	// sorted on another column, it can't
+	q, _ = parseEventQuery(url.Values{"sort": {"summary"}}, hub.severities)
+	q.After = cursor
+	_, err := hub.searchLog(q)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestSearchLogDescendingById(c *C) {
+	hub := setupEventLog()
+
+	q, _ := parseEventQuery(url.Values{"dir": {"desc"}, "results": {"2"}}, hub.severities)
+	page, _ := hub.searchLog(q)
+	c.Assert(page.Total, Equals, 5)
+	c.Assert(page.Entries[0].Summary, Equals, "disk full")
+	c.Assert(page.Entries[1].Summary, Equals, "deploy")
+
+	q.After = page.Next
+	page, _ = hub.searchLog(q)
+	c.Assert(page.Total, Equals, 3)
+	c.Assert(page.Entries[0].Summary, Equals, "backup done")
+	c.Assert(page.Entries[1].Summary, Equals, "request timeout")
+
+	q.After = page.Next
+	page, _ = hub.searchLog(q)
+	c.Assert(len(page.Entries), Equals, 1)
+	c.Assert(page.Entries[0].Summary, Equals, "replica lagging")
+	c.Assert(page.Next, Equals, 0)
+}
+
+func (s *S) TestSearchLogScanIsCapped(c *C) {
+	_, tl, hub := SetupNotifier()
+	now := time.Unix(1000, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	service := hub.AddService(&ServiceConfig{Name: "db", Timeout: time.Hour, NotificationLastMinute: 24*60})
+	for i := range(MAX_EVENT_SCAN + 10) {
+		at := now.Add(time.Duration(i) * time.Second)
+		service.Log.entries = append(service.Log.entries, &LogEntry{ServiceName: "db", Summary: "tick", Severity: INFO, Timestamp: at, LastSeen: at, Count: 1, Sequence: i + 1})
+	}
+
+	q, _ := parseEventQuery(url.Values{}, hub.severities)
+	page, _ := hub.searchLog(q)
+	c.Assert(page.Total, Equals, MAX_EVENT_SCAN)
+	c.Assert(page.Capped, Equals, true)
+
+	// sorted on another column, only the newest entries are searched
+	q, _ = parseEventQuery(url.Values{"sort": {"timestamp"}, "results": {"1"}}, hub.severities)
+	page, _ = hub.searchLog(q)
+	c.Assert(page.Capped, Equals, true)
+	c.Assert(page.Entries[0].Timestamp, Equals, now.Add(10 * time.Second))
+
+	// a sparse filter may fill no page before the cap, but the cursor
+	// leads on to the later matches
+	service.Log.entries[MAX_EVENT_SCAN + 5].Severity = FATAL
+	q, _ = parseEventQuery(url.Values{"min_severity": {"fatal"}}, hub.severities)
+	page, _ = hub.searchLog(q)
+	c.Assert(len(page.Entries), Equals, 0)
+	c.Assert(page.Capped, Equals, true)
+	c.Assert(page.Next, Equals, MAX_EVENT_SCAN)
+	c.Assert(eventPageResult(q, page, hub.severities)["next"], Equals, MAX_EVENT_SCAN)
+
+	q.After = page.Next
+	page, _ = hub.searchLog(q)
+	c.Assert(len(page.Entries), Equals, 1)
+	c.Assert(page.Entries[0].Sequence, Equals, MAX_EVENT_SCAN + 6)
+	c.Assert(page.Capped, Equals, false)
+	c.Assert(page.Next, Equals, 0)
+
+	_, err := parseEventQuery(url.Values{"regexp": {strings.Repeat("a", MAX_EVENT_REGEXP + 1)}}, hub.severities)
+	c.Assert(err, NotNil)
+}
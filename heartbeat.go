@@ -14,14 +14,24 @@ type HeartbeatMonitor struct {
 	lastHeartbeat time.Time
 	callback HeartbeatFailureCallback
 	failed bool
+	// the pending timeout check, if any
+	pending *Event
+	stopped bool
 }
 
 func (h *HeartbeatMonitor) scheduleHeartbeatTimeout() {
+	if h.pending != nil {
+		h.timeline.Cancel(h.pending)
+	}
 	nextTimeout := h.lastHeartbeat.Add(h.period)
-	h.timeline.Schedule(nextTimeout, func() { h.checkHeartbeatTimeout() } )
+	h.pending = h.timeline.Schedule(nextTimeout, func() { h.checkHeartbeatTimeout() } )
 }
 
 func (h *HeartbeatMonitor) checkHeartbeatTimeout() {
+	h.pending = nil
+	if h.stopped {
+		return
+	}
 	if h.timeline.Now().Sub(h.lastHeartbeat) >= h.period {
 		log.Println("failure",h.name);
 		h.failed = true
@@ -34,7 +44,28 @@ func (h *HeartbeatMonitor) Start() {
 	h.scheduleHeartbeatTimeout()
 }
 
+// cancels any pending timeout.  Once stopped, the monitor ignores heartbeats.
+func (h *HeartbeatMonitor) Stop() {
+	h.stopped = true
+	if h.pending != nil {
+		h.timeline.Cancel(h.pending)
+		h.pending = nil
+	}
+}
+
+// changes the timeout, rescheduling the pending check relative to the 
+// last heartbeat
+func (h *HeartbeatMonitor) SetPeriod(period time.Duration) {
+	h.period = period
+	if !h.stopped && !h.failed {
+		h.scheduleHeartbeatTimeout()
+	}
+}
+
 func (h *HeartbeatMonitor) Heartbeat() {
+	if h.stopped {
+		return
+	}
 	h.lastHeartbeat = h.timeline.Now()
 	if h.failed {
 		log.Println("okay",h.name);
@@ -45,6 +76,6 @@ func (h *HeartbeatMonitor) Heartbeat() {
 }
 
 func NewHeartbeatMonitor ( timeline *Timeline, name string, period time.Duration, callback HeartbeatFailureCallback) *HeartbeatMonitor {
-	m := &HeartbeatMonitor{timeline, name, period, time.Now(), callback, false}
+	m := &HeartbeatMonitor{timeline: timeline, name: name, period: period, lastHeartbeat: time.Now(), callback: callback}
 	return m
 }
@@ -3,6 +3,7 @@ package main
 import (
 	. "launchpad.net/gocheck"
 	"bytes"
+	"time"
 )
 
 
@@ -12,11 +13,11 @@ func (s *S) TestHeartbeatFailure(c *C) {
 		b.WriteString(name)
 	}
 
-	tl := NewTimeline(new(SimulatedTimer))
-	m := NewHeartbeatMonitor(tl, "n", 20, callback)
+	tl := simulatedTimeline()
+	m := NewHeartbeatMonitor(tl, "n", 20 * time.Second, callback)
 	m.Start()
 
-	tl.RunUntil(1000)
+	tl.RunUntil(time.Unix(1000, 0))
 	
 	c.Assert(b.String(), Equals, "n")
 }
@@ -32,14 +33,14 @@ func (s *S) TestHeartbeatSuccess(c *C) {
 		}
 	}
 
-	tl := NewTimeline(new(SimulatedTimer))
-	m := NewHeartbeatMonitor(tl, "n", 20, callback)
+	tl := simulatedTimeline()
+	m := NewHeartbeatMonitor(tl, "n", 20 * time.Second, callback)
 	
-	tl.Schedule(100, func() { m.Heartbeat() })
+	tl.Schedule(time.Unix(100, 0), func() { m.Heartbeat() })
 	
 	m.Start()
 
-	tl.RunUntil(1000)
+	tl.RunUntil(time.Unix(1000, 0))
 	
 	c.Assert(b.String(), Equals, "nfncnf")
 }
\ No newline at end of file
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"sort"
+	"time"
+	)
+
+// An incident collects everything that happened to a service while it had
+// a problem.  A WARN or worse entry (heartbeat failures included) opens an
+// incident if the service doesn't already have one, and later WARN or
+// worse entries are attached to it, keeping the first and the latest
+// MAX_INCIDENT_EVENTS.  The incident is resolved when the service
+// recovers, that is its heartbeat comes back or it logs an OKAY entry, by
+// hand, or when the service is removed.  Resolved incidents are kept for
+// INCIDENT_HISTORY_LENGTH, and at most MAX_RESOLVED_INCIDENTS of them.
+
+const (
+	INCIDENT_OPEN = "open"
+	INCIDENT_ACKNOWLEDGED = "acknowledged"
+	INCIDENT_RESOLVED = "resolved"
+	)
+
+const INCIDENT_HISTORY_LENGTH = 30 * 24 * time.Hour
+const MAX_RESOLVED_INCIDENTS = 1000
+const MAX_INCIDENT_EVENTS = 500
+
+type IncidentEvent struct {
+	Timestamp time.Time
+	Description string
+}
+
+type Incident struct {
+	Id int
+	ServiceName string
+	State string
+	// the summary of the entry which opened the incident
+	Title string
+	Opened time.Time
+	Resolved time.Time
+	Assignee string
+	// sequence numbers of the log entries attached to the incident
+	Entries []int
+	Timeline []*IncidentEvent
+}
+
+type IncidentEventSnapshot struct {
+	Timestamp string
+	Description string
+}
+
+type IncidentSnapshot struct {
+	Id int
+	Service string
+	State string
+	Title string
+	Opened string
+	Resolved string
+	Duration string
+	Assignee string
+	IsOpen bool
+	IsAcknowledged bool
+	IsResolved bool
+	Entries []int
+	Timeline []*IncidentEventSnapshot
+}
+
+func (i *Incident) addEvent(timestamp time.Time, description string) {
+	i.Timeline = append(i.Timeline, &IncidentEvent{timestamp, description})
+	if len(i.Timeline) > MAX_INCIDENT_EVENTS {
+		// keep how it was opened
+		i.Timeline = append(i.Timeline[:1], i.Timeline[2:]...)
+	}
+}
+
+func (i *Incident) addEntry(sequence int) {
+	i.Entries = append(i.Entries, sequence)
+	if len(i.Entries) > MAX_INCIDENT_EVENTS {
+		i.Entries = append(i.Entries[:1], i.Entries[2:]...)
+	}
+}
+
+// how long the incident lasted, or has lasted so far
+func (i *Incident) Duration(now time.Time) time.Duration {
+	if i.State == INCIDENT_RESOLVED {
+		return i.Resolved.Sub(i.Opened)
+	}
+	return now.Sub(i.Opened)
+}
+
+func (i *Incident) snapshot(now time.Time) *IncidentSnapshot {
+	timeline := make([]*IncidentEventSnapshot, 0, len(i.Timeline))
+	for _, e := range(i.Timeline) {
+		timeline = append(timeline, &IncidentEventSnapshot{e.Timestamp.Format(time.Stamp), e.Description})
+	}
+
+	resolved := ""
+	if i.State == INCIDENT_RESOLVED {
+		resolved = i.Resolved.Format(time.Stamp)
+	}
+
+	return &IncidentSnapshot{i.Id, i.ServiceName, i.State, i.Title,
+		i.Opened.Format(time.Stamp), resolved, i.Duration(now).Round(time.Second).String(),
+		i.Assignee,
+		i.State == INCIDENT_OPEN, i.State == INCIDENT_ACKNOWLEDGED, i.State == INCIDENT_RESOLVED,
+		append([]int{}, i.Entries...), timeline}
+}
+
+// the unresolved incident for a service, or nil
+func (h *ServiceHub) openIncident(serviceName string) *Incident {
+	return h.openIncidents[serviceName]
+}
+
+// opens, updates or resolves the service's incident for a new log entry
+func (h *ServiceHub) trackIncident(entry *LogEntry) {
+	incident := h.openIncident(entry.ServiceName)
+
+	if incident == nil {
+		if entry.Severity < WARN {
+			return
+		}
+		id := h.nextSequenceId()
+		incident = &Incident{Id: id, ServiceName: entry.ServiceName, State: INCIDENT_OPEN, Title: entry.Summary, Opened: entry.Timestamp}
+		h.incidents[id] = incident
+		h.openIncidents[entry.ServiceName] = incident
+		incident.addEvent(entry.Timestamp, "Opened by "+h.severities.Name(entry.Severity)+": "+entry.Summary)
+		h.publishIncident(incident, "opened", "")
+	} else if entry.Severity != OKAY && entry.Severity < WARN {
+		return
+	} else if n := len(incident.Entries); n > 0 && incident.Entries[n - 1] == entry.Sequence {
+		// a repeat of the entry the incident already has
+		return
+	} else {
+		incident.addEvent(entry.Timestamp, h.severities.Name(entry.Severity)+": "+entry.Summary)
+	}
+
+	incident.addEntry(entry.Sequence)
+
+	if entry.Severity == OKAY {
+		h.resolveIncident(incident, entry.Timestamp, "Recovered", "")
+	}
+}
+
+// called when a service's heartbeat returns after a failure
+func (h *ServiceHub) serviceRecovered(serviceName string) {
+	if incident := h.openIncident(serviceName); incident != nil {
+		h.resolveIncident(incident, h.timeline.Now(), "Heartbeat recovered", "")
+	}
+}
+
+func (h *ServiceHub) resolveIncident(incident *Incident, timestamp time.Time, description string, by string) {
+	incident.State = INCIDENT_RESOLVED
+	incident.Resolved = timestamp
+	incident.addEvent(timestamp, description)
+	delete(h.openIncidents, incident.ServiceName)
+	h.resolvedIncidents = append(h.resolvedIncidents, incident.Id)
+	h.publishIncident(incident, "resolved", by)
+	h.pruneIncidents(timestamp)
+}
+
+// forgets resolved incidents that are too old or too many
+func (h *ServiceHub) pruneIncidents(now time.Time) {
+	cutoff := now.Add(-INCIDENT_HISTORY_LENGTH)
+	drop := 0
+	for drop < len(h.resolvedIncidents) {
+		incident := h.incidents[h.resolvedIncidents[drop]]
+		if len(h.resolvedIncidents) - drop <= MAX_RESOLVED_INCIDENTS && incident.Resolved.After(cutoff) {
+			break
+		}
+		delete(h.incidents, incident.Id)
+		drop += 1
+	}
+	h.resolvedIncidents = h.resolvedIncidents[drop:]
+}
+
+func (h *ServiceHub) findIncident(id int) (*Incident, *ApiError) {
+	incident, exists := h.incidents[id]
+	if !exists {
+		return nil, &ApiError{"No incident with that id"}
+	}
+	return incident, nil
+}
+
+func (h *ServiceHub) AcknowledgeIncident(id int, by string) *ApiError {
+	incident, err := h.findIncident(id)
+	if err != nil {
+		return err
+	}
+	if incident.State != INCIDENT_OPEN {
+		return &ApiError{"Incident is already "+incident.State}
+	}
+
+	incident.State = INCIDENT_ACKNOWLEDGED
+	if incident.Assignee == "" {
+		incident.Assignee = by
+	}
+	incident.addEvent(h.timeline.Now(), "Acknowledged by "+describeUser(by))
+	h.publishIncident(incident, "acknowledged", by)
+	return nil
+}
+
+func (h *ServiceHub) ResolveIncident(id int, by string) *ApiError {
+	incident, err := h.findIncident(id)
+	if err != nil {
+		return err
+	}
+	if incident.State == INCIDENT_RESOLVED {
+		return &ApiError{"Incident is already resolved"}
+	}
+
+	h.resolveIncident(incident, h.timeline.Now(), "Resolved by "+describeUser(by), by)
+	return nil
+}
+
+func (h *ServiceHub) AssignIncident(id int, assignee string, by string) *ApiError {
+	incident, err := h.findIncident(id)
+	if err != nil {
+		return err
+	}
+
+	incident.Assignee = assignee
+	if assignee == "" {
+		incident.addEvent(h.timeline.Now(), "Unassigned by "+describeUser(by))
+	} else {
+		incident.addEvent(h.timeline.Now(), "Assigned to "+assignee+" by "+describeUser(by))
+	}
+	h.publishIncident(incident, "assigned", by)
+	return nil
+}
+
+func describeUser(name string) string {
+	if name == "" {
+		return "anonymous"
+	}
+	return name
+}
+
+func (a *ServiceHubAdapter) GetIncidents() []*IncidentSnapshot {
+	c := make(chan []*IncidentSnapshot)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		ids := make([]int, 0, len(hub.incidents))
+		for id, _ := range(hub.incidents) {
+			ids = append(ids, id)
+		}
+		// newest first
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+
+		now := hub.timeline.Now()
+		is := make([]*IncidentSnapshot, 0, len(ids))
+		for _, id := range(ids) {
+			is = append(is, hub.incidents[id].snapshot(now))
+		}
+
+		c <- is
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) GetIncident(id int) *IncidentSnapshot {
+	c := make(chan *IncidentSnapshot)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		incident, exists := hub.incidents[id]
+		if !exists {
+			c <- nil
+			return
+		}
+		c <- incident.snapshot(hub.timeline.Now())
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) AcknowledgeIncident(id int, by string) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.AcknowledgeIncident(id, by)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) ResolveIncident(id int, by string) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.ResolveIncident(id, by)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) AssignIncident(id int, assignee string, by string) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.AssignIncident(id, assignee, by)
+	})
+
+	return <-c
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestIncidentLifecycle(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+
+	// below WARN doesn't open an incident
+	hub.Log("a", "starting", INFO, now)
+	c.Assert(len(hub.incidents), Equals, 0)
+
+	hub.Log("a", "disk almost full", WARN, now)
+	hub.Log("a", "retrying", INFO, now.Add(time.Minute))
+	c.Assert(len(hub.incidents), Equals, 1)
+
+	// and isn't attached to one
+	incident := hub.openIncident("a")
+	c.Assert(incident.Title, Equals, "disk almost full")
+	c.Assert(incident.State, Equals, INCIDENT_OPEN)
+	c.Assert(len(incident.Entries), Equals, 1)
+
+	c.Assert(hub.AcknowledgeIncident(incident.Id, "alice"), IsNil)
+	c.Assert(incident.State, Equals, INCIDENT_ACKNOWLEDGED)
+	c.Assert(incident.Assignee, Equals, "alice")
+	c.Assert(hub.AcknowledgeIncident(incident.Id, "bob"), NotNil)
+
+	// an OKAY entry means the service recovered
+	hub.Log("a", "disk cleaned up", OKAY, now.Add(5 * time.Minute))
+	c.Assert(incident.State, Equals, INCIDENT_RESOLVED)
+	c.Assert(incident.Duration(now.Add(time.Hour)), Equals, 5 * time.Minute)
+	c.Assert(hub.openIncident("a") == nil, Equals, true)
+
+	// the next problem opens a new incident
+	hub.Log("a", "disk full", ERROR, now.Add(10 * time.Minute))
+	c.Assert(len(hub.incidents), Equals, 2)
+	c.Assert(hub.ResolveIncident(hub.openIncident("a").Id, "bob"), IsNil)
+}
+
+func (s *S) TestIncidentHeartbeatRecovery(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: 10 * time.Second, Enabled: true, NotificationLastMinute: 24*60})
+
+	tl.RunUntil(now.Add(15 * time.Second))
+	incident := hub.openIncident("a")
+	c.Assert(incident, NotNil)
+	c.Assert(incident.Title, Equals, "Heartbeat failure")
+
+	hub.Heartbeat("a")
+	c.Assert(incident.State, Equals, INCIDENT_RESOLVED)
+}
+
+func (s *S) TestIncidentClosedWhenServiceRemoved(c *C) {
+	_, tl, hub := SetupNotifier()
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.Log("a", "disk full", ERROR, now)
+	incident := hub.openIncident("a")
+	c.Assert(incident, NotNil)
+
+	c.Assert(hub.RemoveService("a"), IsNil)
+	c.Assert(hub.openIncident("a"), IsNil)
+	c.Assert(incident.State, Equals, INCIDENT_RESOLVED)
+	c.Assert(incident.Timeline[len(incident.Timeline) - 1].Description, Equals, "Service removed")
+
+	// a service of the same name starts afresh
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.Log("a", "disk full", ERROR, now)
+	c.Assert(hub.openIncident("a") == incident, Equals, false)
+}
+
+func (s *S) TestIncidentPruning(c *C) {
+	_, tl, hub := SetupNotifier()
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: 10000 * time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.Log("a", "disk full", ERROR, now)
+	hub.Log("a", "disk cleaned up", OKAY, now.Add(time.Minute))
+	c.Assert(len(hub.incidents), Equals, 1)
+
+	later := now.Add(INCIDENT_HISTORY_LENGTH + time.Hour)
+	hub.Log("a", "disk full", ERROR, later)
+	c.Assert(len(hub.incidents), Equals, 2)
+	hub.Log("a", "disk cleaned up", OKAY, later.Add(time.Minute))
+	c.Assert(len(hub.incidents), Equals, 1)
+
+	for i := range(MAX_RESOLVED_INCIDENTS + 5) {
+		at := later.Add(time.Duration(i + 2) * time.Minute)
+		hub.Log("a", "disk full", ERROR, at)
+		hub.Log("a", "disk cleaned up", OKAY, at.Add(time.Second))
+	}
+	c.Assert(len(hub.incidents), Equals, MAX_RESOLVED_INCIDENTS)
+	c.Assert(len(hub.resolvedIncidents), Equals, MAX_RESOLVED_INCIDENTS)
+}
+
+func (s *S) TestIncidentEventsCapped(c *C) {
+	_, tl, hub := SetupNotifier()
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: 10000 * time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.Log("a", "disk full", ERROR, now)
+	incident := hub.openIncident("a")
+	c.Assert(hub.AcknowledgeIncident(incident.Id, "alice"), IsNil)
+
+	for i := range(MAX_INCIDENT_EVENTS + 10) {
+		at := now.Add(time.Duration(i + 1) * time.Second)
+		hub.Log("a", fmt.Sprintf("still full %d", i), WARN, at)
+		hub.Log("a", "chatter", DEBUG, at)
+	}
+	c.Assert(len(incident.Entries), Equals, MAX_INCIDENT_EVENTS)
+	c.Assert(len(incident.Timeline), Equals, MAX_INCIDENT_EVENTS)
+	c.Assert(incident.Timeline[0].Description, Equals, "Opened by ERROR: disk full")
+	c.Assert(incident.Timeline[len(incident.Timeline) - 1].Description, Equals, fmt.Sprintf("WARN: still full %d", MAX_INCIDENT_EVENTS + 9))
+}
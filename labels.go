@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	)
+
+// Services carry arbitrary key/value labels (team, env, tier...) which can
+// be matched with a selector such as "team=payments,env!=dev,critical".
+// Each comma separated term must hold:
+//
+//   key=value    the label is set to value
+//   key!=value   the label is not set to value (or not set at all)
+//   key          the label is set
+//   !key         the label is not set
+//
+// Every service also has the implicit labels "service" and "group".
+
+type labelRequirement struct {
+	key string
+	op string
+	value string
+}
+
+type LabelSelector []labelRequirement
+
+func isValidLabelKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range(key) {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' || r == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+func ParseLabelSelector(text string) (LabelSelector, error) {
+	selector := make(LabelSelector, 0)
+
+	for _, term := range(strings.Split(text, ",")) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req labelRequirement
+		if i := strings.Index(term, "!="); i >= 0 {
+			req = labelRequirement{strings.TrimSpace(term[:i]), "!=", strings.TrimSpace(term[i+2:])}
+		} else if i := strings.Index(term, "="); i >= 0 {
+			req = labelRequirement{strings.TrimSpace(term[:i]), "=", strings.TrimSpace(term[i+1:])}
+		} else if strings.HasPrefix(term, "!") {
+			req = labelRequirement{strings.TrimSpace(term[1:]), "!", ""}
+		} else {
+			req = labelRequirement{term, "", ""}
+		}
+
+		if !isValidLabelKey(req.key) {
+			return nil, errors.New("invalid label selector term \""+term+"\"")
+		}
+
+		selector = append(selector, req)
+	}
+
+	return selector, nil
+}
+
+func (sel LabelSelector) Matches(labels map[string] string) bool {
+	for _, req := range(sel) {
+		value, exists := labels[req.key]
+		switch req.op {
+		case "=":
+			if !exists || value != req.value {
+				return false
+			}
+		case "!=":
+			if exists && value == req.value {
+				return false
+			}
+		case "!":
+			if exists {
+				return false
+			}
+		default:
+			if !exists {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (sel LabelSelector) String() string {
+	terms := make([]string, 0, len(sel))
+	for _, req := range(sel) {
+		switch req.op {
+		case "!":
+			terms = append(terms, "!"+req.key)
+		case "":
+			terms = append(terms, req.key)
+		default:
+			terms = append(terms, req.key+req.op+req.value)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// the labels selectors are matched against, including the implicit ones
+func selectorLabels(name string, group string, labels map[string] string) map[string] string {
+	result := make(map[string] string, len(labels) + 2)
+	for k, v := range(labels) {
+		result[k] = v
+	}
+	result["service"] = name
+	result["group"] = group
+	return result
+}
+
+type LabelPair struct {
+	Key string
+	Value string
+}
+
+// labels in key order, for display
+func sortedLabels(labels map[string] string) []LabelPair {
+	pairs := make([]LabelPair, 0, len(labels))
+	for k, v := range(labels) {
+		pairs = append(pairs, LabelPair{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestLabelSelector(c *C) {
+	labels := map[string] string{"team": "payments", "env": "prod"}
+
+	for _, text := range([]string{"", "team=payments", "team=payments, env=prod", "env!=dev", "team", "!tier"}) {
+		selector, err := ParseLabelSelector(text)
+		c.Assert(err, IsNil)
+		c.Assert(selector.Matches(labels), Equals, true)
+	}
+
+	for _, text := range([]string{"team=search", "env!=prod", "tier", "!team", "team=payments,env=dev"}) {
+		selector, err := ParseLabelSelector(text)
+		c.Assert(err, IsNil)
+		c.Assert(selector.Matches(labels), Equals, false)
+	}
+
+	_, err := ParseLabelSelector("=payments")
+	c.Assert(err, NotNil)
+
+	selector, _ := ParseLabelSelector(" team = payments ,!tier")
+	c.Assert(selector.String(), Equals, "team=payments,!tier")
+}
+
+func (s *S) TestImplicitLabels(c *C) {
+	selector, _ := ParseLabelSelector("group=cron,service=backup")
+	c.Assert(selector.Matches(selectorLabels("backup", "cron", nil)), Equals, true)
+	c.Assert(selector.Matches(selectorLabels("backup", "default", nil)), Equals, false)
+}
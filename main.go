@@ -3,45 +3,35 @@ package main
 import (
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"io/fs"
 	"net"
-	"strings"
-	"github.com/hoisie/mustache"
+	"bytes"
 	"path"
+	"path/filepath"
 	"encoding/json"
 	"strconv"
+	"strings"
 	"flag"
+	"fmt"
 	"regexp"
-	"io/ioutil"
 	"time"
 	)
 
 type reqHandler struct {
 	hub ThreadSafeServiceHub
-	templateDir string
-}
-
-type optionsDef struct {
-	NotifierThrottle int
-	NotifierCommand string
-	Listen string
-	ResourceDir string
-	Services []serviceDef
-}
-
-type serviceDef struct {
-	Name string
-	Group *string
-	Timeout int
-	Enabled *bool
-	Description *string
-	Link string
-	NotificationsStop *string
-	NotificationsStart *string
+	// views, css, img and js
+	resources fs.FS
+	views *viewSet
+	auth *Authenticator
+	tokens *TokenStore
 }
 
 func (h *reqHandler) render(filename string, context interface{}, w http.ResponseWriter) {
-	t, err := mustache.ParseFile(h.templateDir + "/" + filename)
+	// rendered to a buffer first so a failure doesn't leave half a page
+	b := new(bytes.Buffer)
+	err := h.views.Render(b, filename, context)
 	
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -49,97 +39,77 @@ func (h *reqHandler) render(filename string, context interface{}, w http.Respons
 		return
 	}
 
-	result := t.Render(context)
-	w.Write([]byte(result))
-}
-
-type ServiceGroup struct {
-	Group string
-	Services []*ServiceSnapshot
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
 }
 
 func (h *reqHandler) listServices(w http.ResponseWriter, r *http.Request) {
-	ss := h.hub.GetServices()
+	r.ParseForm()
 
-	// group service by group
-	groups := make(map[string] []*ServiceSnapshot)
-	for _, s := range(ss) {
-		groupServices, hasGroup := groups[s.Group]
-		if !hasGroup {
-			groupServices = make([]*ServiceSnapshot, 0, 100)
-		}
-		// copy because s gets overwritten each iteration
-		t := s
-		groups[s.Group] = append(groupServices, &t)
+	q, err := parseDashboardQuery(r.Form)
+	selectorError := ""
+	if err != nil {
+		selectorError = err.Error()
 	}
 
-	sg := make([]*ServiceGroup, 0, len(groups))
-	for g, servicesForGroup := range(groups) {
-		sg = append(sg, &ServiceGroup{g, servicesForGroup})
+	groups, summary := buildDashboard(h.hub.GetServices(), q)
+
+	sortUrls := make(map[string] string)
+	for name := range(dashboardSorts) {
+		sortName := name
+		sortUrls[name] = dashboardUrl(q.Values(func(values url.Values) { values.Set("sort", sortName) }))
+	}
+	problemsUrl := dashboardUrl(q.Values(func(values url.Values) {
+		if q.ProblemsOnly {
+			values.Del("problems")
+		} else {
+			values.Set("problems", "1")
+		}
+	}))
+
+	openIncidents := 0
+	for _, incident := range(h.hub.GetIncidents()) {
+		if !incident.IsResolved {
+			openIncidents += 1
+		}
 	}
 
-	h.render("dashboard.tpl", map[string]interface{}{"groups":sg}, w)
+	h.render("dashboard.tpl", map[string]interface{}{"groups": groups,
+		"summary": summary,
+		"query": q,
+		"collapsed": q.Values(nil)["collapse"],
+		"sortUrls": sortUrls,
+		"problemsUrl": problemsUrl,
+		"openIncidents": openIncidents,
+		"user": requestUser(r),
+		"authEnabled": h.auth.Enabled(),
+		"isAdmin": requestUser(r).Can(ROLE_ADMIN),
+		"selector": q.SelectorString,
+		"selectorError": selectorError,
+		"silences": h.hub.GetSilences()}, w)
 }
 
+// a page of events as json, for the events tables.  See parseEventQuery
+// for the parameters.
 func (h *reqHandler) listEventsData(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	serviceName, exists := r.Form["service"]
-	if ! exists {
-//		log.Println("no service")
-		return
-	}
-
-	startIndex := 0
-	pageSize := 50
-
-	startIndexStr, startIndexStrExists := r.Form["startIndex"]
-	if startIndexStrExists {
-		startIndex, _ = strconv.Atoi(startIndexStr[0])	
-	}
+	severities := h.hub.GetSeverities()
 
-	if pageSizeStr, pageSizeStrExists := r.Form["results"] ; pageSizeStrExists {
-		pageSize, _ = strconv.Atoi(pageSizeStr[0])
+	q, err := parseEventQuery(r.Form, severities)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	entries := h.hub.GetLogEntries(serviceName[0])
-
-	transformed := make([] map [string] interface{}, 0, 100)
-	for index, l := range(entries) {
-		if index < startIndex {
-			// kinda stupid to loop through until we get to startIndex, but 
-			// on a plane and no internet.  Can't look up the go docs at the
-			// moment to see if I can give range a start/stop index.
-			continue;
-		}
-
-		t := make(map[string] interface{})
-		t["service"] = l.ServiceName
-		t["summary"] = l.Summary
-		t["severity"] = l.Severity
-		t["timestamp"] = l.Timestamp
-		t["id"] = l.Sequence
-
-		transformed = append(transformed, t)
-
-		// once we've got a full page, stop
-		if len(transformed) >= pageSize {
-			break;
-		}
+	page, apiErr := h.hub.SearchLog(q)
+	if apiErr != nil {
+		http.Error(w, apiErr.String(), http.StatusConflict)
+		return
 	}
 
-	result := map[string]interface{}{"recordsReturned": len(transformed),
-	    "totalRecords": len(entries),
-	    "startIndex": startIndex,
-	    "sort": nil,
-	    "dir": nil,
-	    "pageSize": pageSize,
-	    "records": transformed }
-
-
-//	log.Println("write result")
-
+	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
-	enc.Encode(result)
+	enc.Encode(eventPageResult(q, page, severities))
 }
 
 func (h *reqHandler) showServiceStatus(w http.ResponseWriter, r *http.Request) {
@@ -184,6 +154,49 @@ func (h *reqHandler) listEvents(w http.ResponseWriter, r *http.Request) {
 	h.render("table.tpl", map[string]interface{}{"service":serviceName, "filters": filters}, w)
 }
 
+// the events of every service, filtered by the form on the page
+func (h *reqHandler) listAllEvents(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	form := make(map[string] string)
+	for _, key := range([]string{"service", "group", "min_severity", "max_severity", "since", "until", "q", "regexp"}) {
+		form[key] = r.Form.Get(key)
+	}
+
+	h.render("events.tpl", map[string]interface{}{
+		"form": form,
+		"severities": h.hub.GetSeverities().Levels(),
+		}, w)
+}
+
+func (h *reqHandler) showEvent(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "An event id is required", http.StatusBadRequest)
+		return
+	}
+
+	entry := h.hub.GetLogEntry(id)
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.render("event.tpl", map[string]interface{}{
+		"service": entry.ServiceName,
+		"summary": entry.Summary,
+		"severity": h.hub.GetSeverities().Name(entry.Severity),
+		"id": entry.Sequence,
+		"detail": entry.Detail,
+		"timestamp": entry.Timestamp.Format(time.RFC1123),
+		"count": entry.Count,
+		"repeated": entry.Count > 1,
+		"lastSeen": entry.LastSeen.Format(time.RFC1123),
+		"fields": sortedLabels(entry.Fields),
+		}, w)
+}
+
 func (h *reqHandler) removeServiceEvents(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	serviceName, exists := r.Form["service"]
@@ -195,7 +208,8 @@ func (h *reqHandler) removeServiceEvents(w http.ResponseWriter, r *http.Request)
 	for _, entry := range(entries) {
 		h.hub.RemoveLogEntry(entry.Sequence)
 	}
-	http.Redirect(w, r, "/list-events", http.StatusTemporaryRedirect)
+	h.auth.Audit(requestUser(r), "remove-service-events", "%s, %d events", serviceName[0], len(entries))
+	http.Redirect(w, r, "/list-events?service="+url.QueryEscape(serviceName[0]), http.StatusSeeOther)
 }
 
 func (h *reqHandler) removeEvents(w http.ResponseWriter, r *http.Request) {
@@ -208,8 +222,9 @@ func (h *reqHandler) removeEvents(w http.ResponseWriter, r *http.Request) {
 				h.hub.RemoveLogEntry(eventId)
 			}
 		}
+		h.auth.Audit(requestUser(r), "remove-events", "%v", eventIds)
 	}
-	http.Redirect(w, r, "/list-events", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, "/events", http.StatusSeeOther)
 }
 
 func (h *reqHandler) disableService(w http.ResponseWriter, r *http.Request) {
@@ -219,8 +234,9 @@ func (h *reqHandler) disableService(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	h.hub.SetServiceEnabled(serviceName[0], false)
+	h.auth.Audit(requestUser(r), "disable-service", "%s", serviceName[0])
 
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (h *reqHandler) enableService(w http.ResponseWriter, r *http.Request) {
@@ -230,8 +246,9 @@ func (h *reqHandler) enableService(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	h.hub.SetServiceEnabled(serviceName[0], true)
+	h.auth.Audit(requestUser(r), "enable-service", "%s", serviceName[0])
 
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (h *reqHandler) addNotificationFilter(w http.ResponseWriter, r *http.Request) {
@@ -251,9 +268,11 @@ func (h *reqHandler) addNotificationFilter(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	h.hub.AddNotificationFilter(serviceName[0], regexp)
+	// an empty field filters on the summary
+	h.hub.AddNotificationFilter(serviceName[0], r.Form.Get("field"), regexp)
+	h.auth.Audit(requestUser(r), "add-notification-filter", "%s %s: %s", serviceName[0], r.Form.Get("field"), exprString[0])
 
-	http.Redirect(w, r, "/list-events?service="+serviceName[0], http.StatusTemporaryRedirect)
+	http.Redirect(w, r, "/list-events?service="+url.QueryEscape(serviceName[0]), http.StatusSeeOther)
 }
 
 func (h *reqHandler) removeNotificationFilter(w http.ResponseWriter, r *http.Request) {
@@ -271,8 +290,191 @@ func (h *reqHandler) removeNotificationFilter(w http.ResponseWriter, r *http.Req
 	id, _ :=  strconv.Atoi(idString[0])
 
 	h.hub.RemoveNotificationFilter(serviceName[0],  id)
+	h.auth.Audit(requestUser(r), "remove-notification-filter", "%s %d", serviceName[0], id)
+
+	http.Redirect(w, r, "/list-events?service="+url.QueryEscape(serviceName[0]), http.StatusSeeOther)
+}
+
+func (h *reqHandler) addSilence(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	selector, err := ParseLabelSelector(r.Form.Get("selector"))
+	if err != nil || len(selector) == 0 {
+		http.Error(w, "A label selector is required", http.StatusBadRequest)
+		return
+	}
+
+	minutes, err := strconv.Atoi(r.Form.Get("minutes"))
+	if err != nil || minutes <= 0 {
+		http.Error(w, "Duration must be a positive number of minutes", http.StatusBadRequest)
+		return
+	}
+
+	id := h.hub.AddSilence(selector, time.Duration(minutes) * time.Minute, r.Form.Get("comment"))
+	h.auth.Audit(requestUser(r), "add-silence", "%d %s for %d minutes: %s", id, r.Form.Get("selector"), minutes, r.Form.Get("comment"))
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *reqHandler) removeSilence(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err == nil && h.hub.RemoveSilence(id) == nil {
+		h.auth.Audit(requestUser(r), "remove-silence", "%d", id)
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
 
-	http.Redirect(w, r, "/list-events?service="+serviceName[0], http.StatusTemporaryRedirect)
+func (h *reqHandler) listIncidents(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	state := r.Form.Get("state")
+
+	incidents := make([]*IncidentSnapshot, 0)
+	for _, incident := range(h.hub.GetIncidents()) {
+		if state == "" || incident.State == state {
+			incidents = append(incidents, incident)
+		}
+	}
+
+	h.render("incidents.tpl", map[string]interface{}{"incidents": incidents, "state": state}, w)
+}
+
+func (h *reqHandler) showService(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	detail := h.hub.GetServiceDetail(r.Form.Get("name"))
+	if detail == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.render("service.tpl", map[string]interface{}{"service": detail}, w)
+}
+
+// the graph page; the data comes from /graph_data/
+func (h *reqHandler) showGraph(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	serviceName := r.Form.Get("service")
+	if h.hub.GetServiceDetail(serviceName) == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	hours, err := strconv.Atoi(r.Form.Get("hours"))
+	if err != nil {
+		hours = 6
+	}
+
+	h.render("graph.tpl", map[string]interface{}{
+		"service": serviceName,
+		"hours": hours,
+		"periods": []int{1, 6, 12, 24},
+		}, w)
+}
+
+func (h *reqHandler) showIncident(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "An incident id is required", http.StatusBadRequest)
+		return
+	}
+
+	incident := h.hub.GetIncident(id)
+	if incident == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.render("incident.tpl", map[string]interface{}{"incident": incident, "user": requestUser(r)}, w)
+}
+
+// handles the acknowledge, resolve and assign forms on the incident page,
+// on behalf of the signed in user
+func (h *reqHandler) updateIncident(w http.ResponseWriter, r *http.Request, action string) {
+	r.ParseForm()
+
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "An incident id is required", http.StatusBadRequest)
+		return
+	}
+	by := requestUser(r).String()
+
+	var apiErr *ApiError
+	switch action {
+	case "acknowledge":
+		apiErr = h.hub.AcknowledgeIncident(id, by)
+	case "resolve":
+		apiErr = h.hub.ResolveIncident(id, by)
+	case "assign":
+		apiErr = h.hub.AssignIncident(id, r.Form.Get("assignee"), by)
+	}
+
+	if apiErr != nil {
+		http.Error(w, apiErr.String(), http.StatusBadRequest)
+		return
+	}
+	h.auth.Audit(requestUser(r), action+"-incident", "%d %s", id, r.Form.Get("assignee"))
+
+	http.Redirect(w, r, "/incident?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+func (h *reqHandler) reloadConfig(w http.ResponseWriter, r *http.Request, reloader *configReloader) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	warnings, err := reloader.Reload()
+	if err != nil {
+		h.auth.Audit(requestUser(r), "reload-config-failed", "%s: %s", reloader.filename, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()+"\n"))
+		return
+	}
+
+	h.auth.Audit(requestUser(r), "reload-config", "%s", reloader.filename)
+	w.Write([]byte("OK\n"))
+	for _, warning := range(warnings) {
+		w.Write([]byte("warning: "+warning+"\n"))
+	}
+}
+
+func (h *reqHandler) listApiTokens(w http.ResponseWriter, r *http.Request) {
+	h.render("tokens.tpl", map[string]interface{}{"tokens": h.tokens.Snapshots()}, w)
+}
+
+// shows the new token once, since only its hash is kept
+func (h *reqHandler) createApiToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	def := tokenDef{Name: strings.TrimSpace(r.Form.Get("name")),
+		Services: splitList(r.Form.Get("services")),
+		Groups: splitList(r.Form.Get("groups")),
+		Methods: splitList(r.Form.Get("methods")),
+		Admin: r.Form.Get("admin") == "1"}
+
+	context := map[string]interface{}{}
+	secret, err := h.tokens.Create(def)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		context["error"] = err.String()
+	} else {
+		h.auth.Audit(requestUser(r), "create-api-token", "%s services %v groups %v methods %v admin %v", def.Name, def.Services, def.Groups, def.Methods, def.Admin)
+		context["created"] = def.Name
+		context["secret"] = secret
+	}
+	context["tokens"] = h.tokens.Snapshots()
+	h.render("tokens.tpl", context, w)
+}
+
+func (h *reqHandler) revokeApiToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	name := r.Form.Get("name")
+	if err := h.tokens.Revoke(name); err != nil {
+		http.Error(w, err.String(), http.StatusBadRequest)
+		return
+	}
+	h.auth.Audit(requestUser(r), "revoke-api-token", "%s", name)
+	http.Redirect(w, r, "/api-tokens", http.StatusSeeOther)
 }
 
 func (h *reqHandler) makeFileServer(directory string) http.HandlerFunc {
@@ -281,37 +483,138 @@ func (h *reqHandler) makeFileServer(directory string) http.HandlerFunc {
 
 		filename := path.Join(directory, urlFilename)
 
-		fi, err := os.Stat(filename)
+		fi, err := fs.Stat(h.resources, filename)
 
 		if err == nil && fi.Mode().IsRegular() {
-			http.ServeFile(w, r, filename)
+			http.ServeFileFS(w, r, h.resources, filename)
+		} else {
+			http.NotFound(w, r)
 		}
 	}
 }
 
-func parseTimeOfDay(tstr string) int {
-	parts := strings.SplitN(tstr, ":", 2)
-	hour, _ := strconv.Atoi(parts[0])
-	minute, _ := strconv.Atoi(parts[1])
-	return hour * 60 + minute
+// listens on a unix domain socket at path with mode, 0600 if nil.  The
+// socket is made in a directory only the hub can use and linked into place
+// once it has that mode, so it's never reachable with the default
+// permissions.  A socket left behind by a previous run is replaced, but
+// not one another hub is still listening on, nor anything else at path.
+func listenUnixSocket(path string, mode *string) (net.Listener, error) {
+	perm := os.FileMode(0600)
+	if mode != nil {
+		m, err := strconv.ParseUint(*mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Mode must be octal, eg 0660", path)
+		}
+		perm = os.FileMode(m)
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode() & os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s: already exists and isn't a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: something is already listening on it", path)
+		}
+		os.Remove(path)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".gospoke-socket-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "socket")
+	l, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, err
+	}
+	// the private name is gone with its directory
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	// unlike a rename, a link won't replace anything which has appeared at
+	// path in the meantime
+	if err = os.Chmod(private, perm); err == nil {
+		err = os.Link(private, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
 }
 
 func main() {
+	newToken := flag.Bool("new-token", false, "print a random token suitable for PingToken and exit")
+	checkConfig := flag.String("check-config", "", "validate the given config file and exit")
+	dev := flag.Bool("dev", false, "reload templates when they change, for working on the UI with ResourceDir")
+	hashPasswordFlag := flag.Bool("hash-password", false, "read a password from stdin, print its hash for the Auth UsersFile and exit")
 	flag.Parse()
 	args := flag.Args()
+
+	if *newToken {
+		fmt.Println(newRandomToken())
+		return
+	}
+
+	if *hashPasswordFlag {
+		if err := hashPassword(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *checkConfig != "" {
+		conf, err := readConfig(*checkConfig)
+		if err == nil {
+			_, err = newHubConfig(conf)
+		}
+		var auth *Authenticator
+		if err == nil {
+			auth, err = newAuthenticator(conf)
+		}
+		var tokens *TokenStore
+		if err == nil {
+			tokens, err = newTokenStore(conf, auth)
+		}
+		if err == nil {
+			err = MewJsonRpcHandler(nil, nil).UseTokens(tokens, nil)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(*checkConfig+": OK")
+		return
+	}
 	
 	configFilename := "gospoke.json"
 	if len(args) > 0 {
 		configFilename = args[0]
 	}
 	
-	var conf optionsDef
-	b, read_err := ioutil.ReadFile(configFilename)
-	if read_err != nil {
-		log.Fatalln(read_err)
+	conf, err := readConfig(configFilename)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	err := json.Unmarshal(b, &conf)
+	hubConfig, err := newHubConfig(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	auth, err := newAuthenticator(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !auth.Enabled() {
+		log.Println("warning: no Auth in the config, so anyone who can reach the web UI can change it")
+	}
+
+	tokens, err := newTokenStore(conf, auth)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -319,95 +622,95 @@ func main() {
 	timeline := NewTimeline(new (RealTimer) )
 	hub := NewServiceHub(timeline)
 
-	notifierCommand := conf.NotifierCommand
-	notifierThrottle := conf.NotifierThrottle
 	listeningAddr := conf.Listen
-	resourceDir := conf.ResourceDir
-
-	notifier := NewNotifier(notifierCommand, time.Duration(notifierThrottle) * time.Second, ExecuteCommand, timeline, hub)
-	
-	for _, s := range(conf.Services) {
-		name := s.Name
-		heartbeatTimeout := s.Timeout
+	notifier := NewNotifier(hubConfig.NotifierCommand, hubConfig.NotifierThrottle, ExecuteCommand, timeline, hub)
+	hub.notifier = notifier
 
-		var group string
-		if s.Group == nil { 
-			group = "default"
-		} else {
-			group = *s.Group
-		}
+	if apiErr := hub.ApplyConfig(hubConfig); apiErr != nil {
+		log.Fatalln(apiErr.String())
+	}
 
-		var description string
-		if s.Description == nil {
-			description = ""
-		} else {
-			description = *s.Description
-		}
+	threadSafeHub := NewHubAdapter(hub)
 
-		enabled := false
-		if s.Enabled != nil {
-			enabled = *s.Enabled
-		}
+	var udp *UdpListener
+	if conf.UdpListen != "" {
+		log.Println("Starting udp listener on "+conf.UdpListen)
 
-		notificationStartTimeStr := "00:00"
-		if s.NotificationsStart != nil {
-			notificationStartTimeStr = *s.NotificationsStart
+		if conf.UdpSecret == "" {
+			log.Println("warning: no UdpSecret in the config, so anyone who can reach "+conf.UdpListen+" can send heartbeats and logs")
 		}
-		notificationStart := parseTimeOfDay(notificationStartTimeStr)
-
-		notificationStopTimeStr := "24:00"
-		if s.NotificationsStop != nil {
-			notificationStopTimeStr = *s.NotificationsStop
+		udp = NewUdpListener(threadSafeHub, timeline, conf.UdpSecret)
+		if e := udp.Listen(conf.UdpListen); e != nil {
+			log.Fatalln(e)
 		}
-		notificationStop := parseTimeOfDay(notificationStopTimeStr)
-
-		hub.AddService(name, time.Duration(heartbeatTimeout) * time.Second, group, description, enabled, notificationStart, notificationStop)
+		go udp.Serve()
 	}
 
-	hub.notifier = notifier
-	threadSafeHub := NewHubAdapter(hub)
-
-	h := &reqHandler{threadSafeHub, resourceDir+"/views"}
+	reloader := &configReloader{filename: configFilename, hub: threadSafeHub, auth: auth, tokens: tokens, udp: udp, started: conf}
+	reloader.ReloadOnSignal()
 
-	http.Handle("/jsonrpc", MewJsonRpcHandler(threadSafeHub, timeline))
-	http.HandleFunc("/blueprint/", h.makeFileServer(resourceDir+"/css/blueprint"))
-	http.HandleFunc("/css/", h.makeFileServer(resourceDir+"/css"))
-	http.HandleFunc("/img/", h.makeFileServer(resourceDir+"/img"))
-	http.HandleFunc("/js/", h.makeFileServer(resourceDir+"/js"))
+	resources := resourceFS(conf.ResourceDir)
+	h := &reqHandler{threadSafeHub, resources, newViewSet(resources, *dev), auth, tokens}
 
-	// I've got enough of these maybe I should refactor somehow
-	// punting because perhaps I can find an existing framework to adopt instead
-	// of rolling my own.
-	http.HandleFunc("/", func (w http.ResponseWriter, r *http.Request) {
-		h.listServices(w, r)
-	})
-	http.HandleFunc("/service-status", func (w http.ResponseWriter, r *http.Request) {
-		h.showServiceStatus(w, r)
-	})
-	http.HandleFunc("/list-events", func (w http.ResponseWriter, r *http.Request) {
-		h.listEvents(w, r)
-	})
-	http.HandleFunc("/list-events-data", func (w http.ResponseWriter, r *http.Request) {
-		h.listEventsData(w, r)
-	})
-	http.HandleFunc("/remove-events", func (w http.ResponseWriter, r *http.Request) {
-		h.removeEvents(w, r)
-	})
-	http.HandleFunc("/remove-service-events", func (w http.ResponseWriter, r *http.Request) {
-		h.removeServiceEvents(w, r)
-	})
-	http.HandleFunc("/disable-service", func (w http.ResponseWriter, r *http.Request) {
-		h.disableService(w, r)
-	})
-	http.HandleFunc("/enable-service", func (w http.ResponseWriter, r *http.Request) {
-		h.enableService(w, r)
-	})
-	http.HandleFunc("/add-notification-filter", func (w http.ResponseWriter, r *http.Request) {
-		h.addNotificationFilter(w, r)
-	})
-	http.HandleFunc("/remove-notification-filter", func (w http.ResponseWriter, r *http.Request) {
-		h.removeNotificationFilter(w, r)
+	rpc := MewJsonRpcHandler(threadSafeHub, timeline)
+	if err := rpc.UseTokens(tokens, threadSafeHub); err != nil {
+		log.Fatalln(err)
+	}
+	http.Handle("/jsonrpc", rpc)
+	http.Handle("/ping/", NewPingHandler(threadSafeHub, timeline))
+	http.Handle("/events/stream", auth.Require(ROLE_VIEWER, NewSseHandler(threadSafeHub).ServeHTTP))
+	http.Handle("/ws", auth.Require(ROLE_VIEWER, NewWebSocketHandler(threadSafeHub).ServeHTTP))
+	http.Handle("/graph_data/", auth.Require(ROLE_VIEWER, NewGraphDataHandler(threadSafeHub).ServeHTTP))
+	http.HandleFunc("/blueprint/", h.makeFileServer("css/blueprint"))
+	http.HandleFunc("/css/", h.makeFileServer("css"))
+	http.HandleFunc("/img/", h.makeFileServer("img"))
+	http.HandleFunc("/js/", h.makeFileServer("js"))
+	http.HandleFunc("/login", func (w http.ResponseWriter, r *http.Request) {
+		auth.login(w, r, h.views)
 	})
+	http.HandleFunc("/logout", auth.logout)
+
+	// pages anyone signed in may see
+	pages := map[string] http.HandlerFunc{
+		"/": h.listServices,
+		"/service-status": h.showServiceStatus,
+		"/list-events": h.listEvents,
+		"/list-events-data": h.listEventsData,
+		"/events": h.listAllEvents,
+		"/event": h.showEvent,
+		"/service": h.showService,
+		"/graph": h.showGraph,
+		"/incidents": h.listIncidents,
+		"/incident": h.showIncident,
+	}
+	for path, handler := range(pages) {
+		http.HandleFunc(path, auth.Require(ROLE_VIEWER, handler))
+	}
+
+	// changes, which operators may make
+	changes := map[string] http.HandlerFunc{
+		"/remove-events": h.removeEvents,
+		"/remove-service-events": h.removeServiceEvents,
+		"/disable-service": h.disableService,
+		"/enable-service": h.enableService,
+		"/add-notification-filter": h.addNotificationFilter,
+		"/remove-notification-filter": h.removeNotificationFilter,
+		"/add-silence": h.addSilence,
+		"/remove-silence": h.removeSilence,
+		"/acknowledge-incident": func (w http.ResponseWriter, r *http.Request) { h.updateIncident(w, r, "acknowledge") },
+		"/resolve-incident": func (w http.ResponseWriter, r *http.Request) { h.updateIncident(w, r, "resolve") },
+		"/assign-incident": func (w http.ResponseWriter, r *http.Request) { h.updateIncident(w, r, "assign") },
+	}
+	for path, handler := range(changes) {
+		http.HandleFunc(path, auth.RequirePost(ROLE_OPERATOR, handler))
+	}
+
+	http.HandleFunc("/reload-config", auth.RequirePost(ROLE_ADMIN, func (w http.ResponseWriter, r *http.Request) {
+		h.reloadConfig(w, r, reloader)
+	}))
+	http.HandleFunc("/api-tokens", auth.Require(ROLE_ADMIN, h.listApiTokens))
+	http.HandleFunc("/create-api-token", auth.RequirePost(ROLE_ADMIN, h.createApiToken))
+	http.HandleFunc("/revoke-api-token", auth.RequirePost(ROLE_ADMIN, h.revokeApiToken))
 
 	
 	log.Println("Starting http server on "+listeningAddr)
@@ -418,6 +721,17 @@ func main() {
 	}
 	go http.Serve(l, nil)
 
+	for _, socket := range(conf.UnixSockets) {
+		socketPath := conf.resolvePath(socket.Path)
+		log.Println("Starting http server on unix socket "+socketPath)
+
+		ul, e := listenUnixSocket(socketPath, socket.Mode)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		go http.Serve(ul, nil)
+	}
+
 	log.Println("Starting timeline")
 	timeline.Run()
 }
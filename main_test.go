@@ -0,0 +1,48 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+func (s *S) TestListenUnixSocket(c *C) {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hub.sock")
+
+	// 0600 unless a mode is given
+	l, err := listenUnixSocket(path, nil)
+	c.Assert(err, IsNil)
+	fi, err := os.Lstat(path)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	// a hub still listening keeps its socket
+	_, err = listenUnixSocket(path, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, ".*already listening.*")
+
+	// once it's gone, the socket it left is replaced
+	l.Close()
+	mode := "0660"
+	l, err = listenUnixSocket(path, &mode)
+	c.Assert(err, IsNil)
+	fi, _ = os.Lstat(path)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0660))
+	conn, err := net.Dial("unix", path)
+	c.Assert(err, IsNil)
+	conn.Close()
+	l.Close()
+
+	// anything else is left alone
+	other := filepath.Join(dir, "notes")
+	ioutil.WriteFile(other, []byte("keep me"), 0644)
+	_, err = listenUnixSocket(other, nil)
+	c.Assert(err, NotNil)
+	data, _ := ioutil.ReadFile(other)
+	c.Assert(string(data), Equals, "keep me")
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	)
+
+// Per-service time series for the graph page: heartbeats, the mean
+// interval between them, status and log entries by severity, in one minute
+// buckets.  Each service keeps a day of buckets in a ring, reusing a slot
+// once its minute has passed out of the window.  The hub has no storage,
+// so the series are kept in memory only and start empty after a restart.
+
+const (
+	METRICS_BUCKET = time.Minute
+	METRICS_BUCKETS = 24 * 60
+	)
+
+type metricsBucket struct {
+	// the bucket's minute as a unix time, or 0 if the slot is unused
+	minute int64
+	heartbeats int
+	// total time between the heartbeats which arrived in this bucket and
+	// the ones before them, and how many such gaps there were
+	intervals time.Duration
+	intervalCount int
+	// status at the end of the bucket, and whether it was down at all
+	status int
+	wasDown bool
+	// by severity level; nil until something is logged
+	logs map[int] int
+}
+
+type ServiceMetrics struct {
+	buckets []metricsBucket
+	lastHeartbeat time.Time
+	// status as of the latest bucket, carried into new ones
+	status int
+}
+
+func newServiceMetrics() *ServiceMetrics {
+	return &ServiceMetrics{buckets: make([]metricsBucket, METRICS_BUCKETS), status: STATUS_UNKNOWN}
+}
+
+// the slot in the ring for the bucket starting at minute
+func (m *ServiceMetrics) slot(minute time.Time) *metricsBucket {
+	i := (minute.Unix() / int64(METRICS_BUCKET / time.Second)) % METRICS_BUCKETS
+	if i < 0 {
+		i += METRICS_BUCKETS
+	}
+	return &m.buckets[i]
+}
+
+// the bucket for t, claiming its slot if it holds an older minute
+func (m *ServiceMetrics) bucket(t time.Time) *metricsBucket {
+	minute := t.Truncate(METRICS_BUCKET).Unix()
+	b := m.slot(t.Truncate(METRICS_BUCKET))
+	if b.minute != minute {
+		*b = metricsBucket{minute: minute, status: m.status, wasDown: m.status == STATUS_DOWN}
+	}
+	return b
+}
+
+func (m *ServiceMetrics) recordHeartbeat(t time.Time) {
+	b := m.bucket(t)
+	b.heartbeats += 1
+	if !m.lastHeartbeat.IsZero() {
+		b.intervals += t.Sub(m.lastHeartbeat)
+		b.intervalCount += 1
+	}
+	m.lastHeartbeat = t
+}
+
+func (m *ServiceMetrics) recordStatus(t time.Time, status int) {
+	m.status = status
+	b := m.bucket(t)
+	b.status = status
+	if status == STATUS_DOWN {
+		b.wasDown = true
+	}
+}
+
+func (m *ServiceMetrics) recordLog(t time.Time, severity int) {
+	b := m.bucket(t)
+	if b.logs == nil {
+		b.logs = make(map[int] int)
+	}
+	b.logs[severity] += 1
+}
+
+type MetricsPoint struct {
+	Time time.Time
+	Heartbeats int
+	// mean time between heartbeats, or 0 if none arrived
+	Interval time.Duration
+	Status int
+	WasDown bool
+	Logs map[int] int
+}
+
+// one point per bucket from since up to now, oldest first.  Minutes with
+// nothing recorded carry the status forward.
+func (m *ServiceMetrics) points(since time.Time, now time.Time) []MetricsPoint {
+	first := now.Add(-(METRICS_BUCKETS - 1) * METRICS_BUCKET).Truncate(METRICS_BUCKET)
+	status := STATUS_UNKNOWN
+	points := make([]MetricsPoint, 0)
+
+	for t := first; !t.After(now); t = t.Add(METRICS_BUCKET) {
+		b := m.slot(t)
+
+		p := MetricsPoint{Time: t, Status: status, WasDown: status == STATUS_DOWN}
+		if b.minute == t.Unix() {
+			p.Heartbeats = b.heartbeats
+			p.Status = b.status
+			p.WasDown = b.wasDown
+			p.Logs = b.logs
+			if b.intervalCount > 0 {
+				p.Interval = b.intervals / time.Duration(b.intervalCount)
+			}
+		}
+		status = p.Status
+
+		if !t.Before(since.Truncate(METRICS_BUCKET)) {
+			points = append(points, p)
+		}
+	}
+
+	return points
+}
+
+// the last period of the service's metrics, or nil if there's no such service
+func (a *ServiceHubAdapter) GetMetrics(serviceName string, period time.Duration) []MetricsPoint {
+	c := make(chan []MetricsPoint)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		s, found := hub.services[serviceName]
+		if !found {
+			c <- nil
+			return
+		}
+		now := hub.timeline.Now()
+		points := s.Metrics.points(now.Add(-period), now)
+		// the logs maps are shared with the buckets, so copy them here
+		for i := range(points) {
+			if points[i].Logs != nil {
+				logs := make(map[int] int)
+				for level, count := range(points[i].Logs) {
+					logs[level] = count
+				}
+				points[i].Logs = logs
+			}
+		}
+		c <- points
+	})
+
+	return <-c
+}
+
+// serves /graph_data/{service}?hours=6&format=json (or csv)
+type graphDataHandler struct {
+	hub ThreadSafeServiceHub
+}
+
+func NewGraphDataHandler(hub ThreadSafeServiceHub) http.Handler {
+	return &graphDataHandler{hub}
+}
+
+func (h *graphDataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serviceName := strings.TrimPrefix(r.URL.Path, "/graph_data/")
+	r.ParseForm()
+
+	hours := 6
+	if s := r.Form.Get("hours"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 24 {
+			http.Error(w, "hours must be between 1 and 24", http.StatusBadRequest)
+			return
+		}
+		hours = n
+	}
+
+	points := h.hub.GetMetrics(serviceName, time.Duration(hours) * time.Hour)
+	if points == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	severities := h.hub.GetSeverities().Levels()
+
+	switch r.Form.Get("format") {
+	case "", "json":
+		writeMetricsJson(w, serviceName, severities, points)
+	case "csv":
+		writeMetricsCsv(w, severities, points)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+func writeMetricsJson(w http.ResponseWriter, serviceName string, severities []*SeverityLevel, points []MetricsPoint) {
+	levels := make([]map[string] string, 0, len(severities))
+	for _, level := range(severities) {
+		levels = append(levels, map[string] string{"name": level.Name, "color": level.Color})
+	}
+
+	series := make([]map[string] interface{}, 0, len(points))
+	for _, p := range(points) {
+		logs := make(map[string] int)
+		for _, level := range(severities) {
+			if count := p.Logs[level.Level]; count > 0 {
+				logs[level.Name] = count
+			}
+		}
+		series = append(series, map[string] interface{}{
+			"time": p.Time.Unix(),
+			"heartbeats": p.Heartbeats,
+			"interval": p.Interval.Seconds(),
+			"status": statusName(p.Status),
+			"was_down": p.WasDown,
+			"logs": logs,
+			})
+	}
+
+	b, err := json.Marshal(map[string] interface{}{
+		"service": serviceName,
+		"step": int(METRICS_BUCKET / time.Second),
+		"severities": levels,
+		"points": series,
+		})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// one row per minute, with a column of log counts for each severity
+func writeMetricsCsv(w http.ResponseWriter, severities []*SeverityLevel, points []MetricsPoint) {
+	b := new(bytes.Buffer)
+	out := csv.NewWriter(b)
+
+	header := []string{"time", "heartbeats", "interval", "status"}
+	for _, level := range(severities) {
+		header = append(header, strings.ToLower(level.Name))
+	}
+	out.Write(header)
+
+	for _, p := range(points) {
+		row := []string{p.Time.UTC().Format(time.RFC3339),
+			strconv.Itoa(p.Heartbeats),
+			strconv.FormatFloat(p.Interval.Seconds(), 'f', -1, 64),
+			statusName(p.Status)}
+		for _, level := range(severities) {
+			row = append(row, strconv.Itoa(p.Logs[level.Level]))
+		}
+		out.Write(row)
+	}
+	out.Flush()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Write(b.Bytes())
+}
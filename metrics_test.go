@@ -0,0 +1,64 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestMetricsPoints(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(6000, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	service := hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+
+	hub.Heartbeat("a")
+	tl.timer.(*SimulatedTimer).time = now.Add(20 * time.Second)
+	hub.Heartbeat("a")
+	tl.timer.(*SimulatedTimer).time = now.Add(40 * time.Second)
+	hub.Heartbeat("a")
+	hub.Log("a", "slow", WARN, now)
+	hub.Log("a", "slow", WARN, now)
+	hub.Log("a", "broken", ERROR, now)
+
+	tl.timer.(*SimulatedTimer).time = now.Add(3 * time.Minute)
+	hub.setStatus(service, STATUS_DOWN)
+
+	end := now.Add(4 * time.Minute)
+	points := service.Metrics.points(now, end)
+	c.Assert(len(points), Equals, 5)
+
+	c.Assert(points[0].Heartbeats, Equals, 3)
+	c.Assert(points[0].Interval, Equals, 20 * time.Second)
+	c.Assert(points[0].Status, Equals, STATUS_UP)
+	c.Assert(points[0].Logs[WARN], Equals, 2)
+	c.Assert(points[0].Logs[ERROR], Equals, 1)
+
+	// quiet minutes carry the status forward
+	c.Assert(points[1].Heartbeats, Equals, 0)
+	c.Assert(points[1].Status, Equals, STATUS_UP)
+	c.Assert(points[3].Status, Equals, STATUS_DOWN)
+	c.Assert(points[4].Status, Equals, STATUS_DOWN)
+}
+
+func (s *S) TestMetricsRingReusesSlots(c *C) {
+	m := newServiceMetrics()
+
+	start := time.Unix(6000, 0)
+	m.recordLog(start, WARN)
+	m.recordStatus(start, STATUS_UP)
+
+	// a day later the same slot holds the new minute only
+	later := start.Add(METRICS_BUCKETS * METRICS_BUCKET)
+	m.recordLog(later, ERROR)
+
+	points := m.points(later, later)
+	c.Assert(len(points), Equals, 1)
+	c.Assert(points[0].Logs[WARN], Equals, 0)
+	c.Assert(points[0].Logs[ERROR], Equals, 1)
+	c.Assert(points[0].Status, Equals, STATUS_UP)
+
+	// the whole window is a day of points, the old minute gone
+	c.Assert(len(m.points(start, later)), Equals, METRICS_BUCKETS)
+}
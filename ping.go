@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	)
+
+// Plain URL endpoints for tools which can only hit a URL:
+//
+//   /ping/{service}          heartbeat
+//   /ping/{service}/start    log that a job started
+//   /ping/{service}/fail     log an error, using the request body as the summary
+//
+// fail logs at ERROR unless a severity is given, eg /ping/{service}/fail?severity=critical
+//
+// If the service has a PingToken configured, it must be included after the
+// service name, eg /ping/{service}/{token}/fail
+
+const PING_MAX_BODY = 10000
+
+type pingHandler struct {
+	hub ThreadSafeServiceHub
+	timeline *Timeline
+}
+
+func NewPingHandler(hub ThreadSafeServiceHub, timeline *Timeline) *pingHandler {
+	return &pingHandler{hub, timeline}
+}
+
+// generates a token suitable for use as a PingToken
+func newRandomToken() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// splits /ping/{service}[/{token}][/{action}] into its parts
+func parsePingPath(urlPath string) (service string, token string, action string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(urlPath, "/ping/"), "/"), "/")
+
+	service = parts[0]
+	parts = parts[1:]
+
+	if len(parts) > 0 {
+		last := parts[len(parts)-1]
+		if last == "start" || last == "fail" {
+			action = last
+			parts = parts[:len(parts)-1]
+		}
+	}
+
+	if len(parts) > 0 {
+		token = parts[0]
+	}
+
+	return
+}
+
+func (p *pingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 Only GET or POST Allowed\n")
+		return
+	}
+
+	serviceName, token, action := parsePingPath(r.URL.Path)
+
+	if err := p.hub.CheckPingToken(serviceName, token); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "403 "+err.String()+"\n")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(r.Body, PING_MAX_BODY))
+	summary := strings.TrimSpace(string(body))
+
+	var err *ApiError
+	switch action {
+	case "fail":
+		if summary == "" {
+			summary = "Ping failure"
+		}
+		severity := ERROR
+		if name := r.URL.Query().Get("severity"); name != "" {
+			var parseErr error
+			if severity, parseErr = p.hub.GetSeverities().Parse(name); parseErr != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, "400 "+parseErr.Error()+"\n")
+				return
+			}
+		}
+		err = p.hub.Log(serviceName, summary, severity, p.timeline.Now())
+	case "start":
+		if summary == "" {
+			summary = "Started"
+		}
+		err = p.hub.Log(serviceName, summary, INFO, p.timeline.Now())
+	default:
+		err = p.hub.Heartbeat(serviceName)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "404 "+err.String()+"\n")
+		return
+	}
+
+	io.WriteString(w, "OK\n")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestParsePingPath(c *C) {
+	service, token, action := parsePingPath("/ping/Alpha")
+	c.Assert(service, Equals, "Alpha")
+	c.Assert(token, Equals, "")
+	c.Assert(action, Equals, "")
+
+	service, token, action = parsePingPath("/ping/Alpha/fail")
+	c.Assert(service, Equals, "Alpha")
+	c.Assert(token, Equals, "")
+	c.Assert(action, Equals, "fail")
+
+	service, token, action = parsePingPath("/ping/Alpha/abc123/start")
+	c.Assert(service, Equals, "Alpha")
+	c.Assert(token, Equals, "abc123")
+	c.Assert(action, Equals, "start")
+
+	service, token, action = parsePingPath("/ping/Alpha/abc123/")
+	c.Assert(service, Equals, "Alpha")
+	c.Assert(token, Equals, "abc123")
+	c.Assert(action, Equals, "")
+}
+
+// calls straight through to a hub, for handler tests with no timeline
+// running.  Methods not needed panic.
+type syncHub struct {
+	ThreadSafeServiceHub
+	hub *ServiceHub
+}
+
+func (s *syncHub) Heartbeat(serviceName string) *ApiError {
+	return s.hub.Heartbeat(serviceName)
+}
+
+func (s *syncHub) CheckPingToken(serviceName string, token string) *ApiError {
+	return s.hub.CheckPingToken(serviceName, token)
+}
+
+func (s *syncHub) Log(serviceName string, summary string, severity int, timestamp time.Time) *ApiError {
+	return s.hub.Log(serviceName, summary, severity, timestamp)
+}
+
+func (s *syncHub) GetSeverities() *SeverityTable {
+	return s.hub.severities
+}
+
+func (s *syncHub) ApplyConfig(conf *HubConfig) *ApiError {
+	return s.hub.ApplyConfig(conf)
+}
+
+func (s *syncHub) GetServices() []ServiceSnapshot {
+	snapshots := make([]ServiceSnapshot, 0, len(s.hub.services))
+	for _, service := range(s.hub.services) {
+		snapshots = append(snapshots, ServiceSnapshot{Name: service.Name, Group: service.Group, Enabled: service.Enabled})
+	}
+	return snapshots
+}
+
+func (s *syncHub) GetServiceGroup(serviceName string) (string, bool) {
+	service, exists := s.hub.services[serviceName]
+	if !exists {
+		return "", false
+	}
+	return service.Group, true
+}
+
+func (s *syncHub) SearchLog(q *EventQuery) (*EventPage, *ApiError) {
+	return s.hub.searchLog(q)
+}
+
+func (s *syncHub) GetIncidents() []*IncidentSnapshot {
+	snapshots := make([]*IncidentSnapshot, 0, len(s.hub.incidents))
+	for _, incident := range(s.hub.incidents) {
+		snapshots = append(snapshots, incident.snapshot(s.hub.timeline.Now()))
+	}
+	return snapshots
+}
+
+func setupPing() (*pingHandler, *ServiceHub) {
+	_, tl, hub := SetupNotifier()
+	tl.timer.(*SimulatedTimer).time = time.Unix(1000, 0)
+	hub.AddService(&ServiceConfig{Name: "open", Timeout: time.Hour, Enabled: true})
+	hub.AddService(&ServiceConfig{Name: "guarded", Timeout: time.Hour, Enabled: true, PingToken: "abc123"})
+	return NewPingHandler(&syncHub{hub: hub}, tl), hub
+}
+
+func ping(p *pingHandler, method string, url string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewBufferString(body)))
+	return w
+}
+
+func (s *S) TestPingToken(c *C) {
+	p, hub := setupPing()
+
+	c.Assert(ping(p, "GET", "/ping/guarded", "").Code, Equals, http.StatusForbidden)
+	c.Assert(ping(p, "GET", "/ping/guarded/wrong", "").Code, Equals, http.StatusForbidden)
+	c.Assert(ping(p, "GET", "/ping/guarded/wrong/fail", "").Code, Equals, http.StatusForbidden)
+	c.Assert(hub.services["guarded"].HeartbeatCount, Equals, 0)
+	c.Assert(len(hub.services["guarded"].Log.entries), Equals, 0)
+
+	c.Assert(ping(p, "GET", "/ping/guarded/abc123", "").Code, Equals, http.StatusOK)
+	c.Assert(hub.services["guarded"].HeartbeatCount, Equals, 1)
+
+	// unknown services fail the token check
+	c.Assert(ping(p, "GET", "/ping/missing", "").Code, Equals, http.StatusForbidden)
+	c.Assert(ping(p, "DELETE", "/ping/open", "").Code, Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *S) TestPingFail(c *C) {
+	p, hub := setupPing()
+
+	c.Assert(ping(p, "POST", "/ping/open/fail", "  backup failed: disk full\n").Code, Equals, http.StatusOK)
+	c.Assert(ping(p, "POST", "/ping/open/fail", "").Code, Equals, http.StatusOK)
+	c.Assert(ping(p, "POST", "/ping/open/fail?severity=critical", "corrupt").Code, Equals, http.StatusOK)
+	c.Assert(ping(p, "POST", "/ping/open/fail?severity=5", "numbered").Code, Equals, http.StatusOK)
+	c.Assert(ping(p, "POST", "/ping/open/start", "").Code, Equals, http.StatusOK)
+	c.Assert(ping(p, "POST", "/ping/open/fail?severity=bogus", "x").Code, Equals, http.StatusBadRequest)
+
+	entries := hub.services["open"].Log.entries
+	c.Assert(len(entries), Equals, 5)
+
+	summaries := make(map[string] int)
+	for _, entry := range(entries) {
+		summaries[entry.Summary] = entry.Severity
+	}
+	c.Assert(summaries, DeepEquals, map[string] int{
+		"backup failed: disk full": ERROR,
+		"Ping failure": ERROR,
+		"corrupt": CRITICAL,
+		"numbered": 5,
+		"Started": INFO})
+}
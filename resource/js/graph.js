@@ -0,0 +1,162 @@
+// Draws a service's graph_data as SVG: a status strip, the mean heartbeat
+// interval and log entries per minute stacked by severity.  Reloads every
+// minute, when a new point is due.
+(function() {
+	var svgNs = "http://www.w3.org/2000/svg";
+	var width = 950;
+	var statusColors = {up: "#4C4", down: "#D33", unknown: "#BBB"};
+	// for severities without a color of their own
+	var palette = ["#999", "#69C", "#393", "#E90", "#D33", "#909", "#600", "#366"];
+
+	var element = function(name, attributes) {
+		var e = document.createElementNS(svgNs, name);
+		for (var key in attributes) {
+			e.setAttribute(key, attributes[key]);
+		}
+		return e;
+	};
+
+	var svg = function(container, height) {
+		var s = element("svg", {width: width, height: height});
+		container.innerHTML = "";
+		container.appendChild(s);
+		return s;
+	};
+
+	var twodigits = function(x) {
+		return (x >= 10 ? "": "0")+x;
+	};
+
+	var formatTime = function(seconds) {
+		var d = new Date(seconds * 1000);
+		return twodigits(d.getHours()) + ":" + twodigits(d.getMinutes());
+	};
+
+	var label = function(s, x, y, text, anchor) {
+		var t = element("text", {x: x, y: y, "font-size": 10, "text-anchor": anchor || "start"});
+		t.textContent = text;
+		s.appendChild(t);
+	};
+
+	// labels the first, middle and last points along the bottom
+	var timeAxis = function(s, points, step, height) {
+		var n = points.length;
+		label(s, 0, height - 2, formatTime(points[0].time));
+		label(s, width / 2, height - 2, formatTime(points[Math.floor(n / 2)].time), "middle");
+		label(s, width, height - 2, formatTime(points[n - 1].time), "end");
+	};
+
+	var drawStatus = function(points, step) {
+		var s = svg(document.getElementById("graph-status"), 20);
+		var w = width / points.length;
+		// one rect per run of the same status
+		for (var i = 0; i < points.length; ) {
+			var j = i;
+			while (j < points.length && points[j].status == points[i].status) {
+				j++;
+			}
+			var r = element("rect", {x: i * w, y: 0, width: (j - i) * w, height: 20, fill: statusColors[points[i].status]});
+			var title = element("title", {});
+			title.textContent = points[i].status + " " + formatTime(points[i].time) + " - " + formatTime(points[j - 1].time + step);
+			r.appendChild(title);
+			s.appendChild(r);
+			i = j;
+		}
+	};
+
+	var drawHeartbeats = function(points, step) {
+		var height = 120, plot = 100;
+		var s = svg(document.getElementById("graph-heartbeats"), height);
+		var max = 0;
+		for (var i = 0; i < points.length; i++) {
+			max = Math.max(max, points[i].interval);
+		}
+		if (max == 0) {
+			label(s, 0, 20, "No heartbeats");
+			return;
+		}
+
+		var w = width / points.length;
+		var path = "";
+		for (var i = 0; i < points.length; i++) {
+			if (points[i].heartbeats == 0) {
+				continue;
+			}
+			var x = (i + 0.5) * w;
+			var y = plot - plot * points[i].interval / max;
+			path += (path == "" || points[i - 1].heartbeats == 0 ? "M" : "L") + x.toFixed(1) + " " + y.toFixed(1) + " ";
+		}
+		s.appendChild(element("path", {d: path, stroke: "#369", fill: "none"}));
+		label(s, 0, 10, max.toFixed(1) + "s");
+		timeAxis(s, points, step, height);
+	};
+
+	var drawLogs = function(points, severities, step) {
+		var height = 120, plot = 100;
+		var s = svg(document.getElementById("graph-logs"), height);
+		var max = 0;
+		for (var i = 0; i < points.length; i++) {
+			var total = 0;
+			for (var name in points[i].logs) {
+				total += points[i].logs[name];
+			}
+			max = Math.max(max, total);
+		}
+		if (max == 0) {
+			label(s, 0, 20, "No log entries");
+		} else {
+			var w = width / points.length;
+			for (var i = 0; i < points.length; i++) {
+				var y = plot;
+				for (var k = 0; k < severities.length; k++) {
+					var count = points[i].logs[severities[k].name] || 0;
+					if (count == 0) {
+						continue;
+					}
+					var h = plot * count / max;
+					y -= h;
+					var r = element("rect", {x: i * w, y: y, width: Math.max(w, 1), height: h, fill: severities[k].color});
+					var title = element("title", {});
+					title.textContent = formatTime(points[i].time) + " " + count + " " + severities[k].name;
+					r.appendChild(title);
+					s.appendChild(r);
+				}
+			}
+			label(s, 0, 10, max + "");
+			timeAxis(s, points, step, height);
+		}
+
+		var legend = document.getElementById("graph-legend");
+		legend.innerHTML = "";
+		for (var k = 0; k < severities.length; k++) {
+			var span = document.createElement("span");
+			span.className = "label";
+			span.style.backgroundColor = severities[k].color;
+			span.textContent = severities[k].name;
+			legend.appendChild(span);
+			legend.appendChild(document.createTextNode(" "));
+		}
+	};
+
+	var refresh = function() {
+		var service = document.getElementById("graph-service").value;
+		var hours = document.getElementById("graph-hours").value;
+		var req = new XMLHttpRequest();
+		req.open("GET", "/graph_data/" + encodeURIComponent(service) + "?hours=" + hours);
+		req.onload = function() {
+			var data = JSON.parse(req.responseText);
+			for (var k = 0; k < data.severities.length; k++) {
+				if (!data.severities[k].color) {
+					data.severities[k].color = palette[k % palette.length];
+				}
+			}
+			drawStatus(data.points, data.step);
+			drawHeartbeats(data.points, data.step);
+			drawLogs(data.points, data.severities, data.step);
+		};
+		req.send();
+	};
+
+	refresh();
+	setInterval(refresh, 60 * 1000);
+})();
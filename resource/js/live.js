@@ -0,0 +1,71 @@
+// Keeps the dashboard up to date from the hub's event stream, so a wall
+// screen doesn't need refreshing.
+(function() {
+	if (!window.EventSource) {
+		return;
+	}
+
+	var statusImages = {up: "img/green.png", down: "img/red.png", unknown: "img/gray.png"};
+
+	var findRow = function(service) {
+		var rows = document.querySelectorAll("tr[data-service]");
+		for (var i = 0; i < rows.length; i++) {
+			if (rows[i].getAttribute("data-service") == service) {
+				return rows[i];
+			}
+		}
+		return null;
+	};
+
+	var source = new EventSource("/events/stream");
+
+	source.addEventListener("status", function(e) {
+		var data = JSON.parse(e.data);
+		var row = findRow(data.service);
+		if (row) {
+			row.querySelector("img.status").src = statusImages[data.status];
+		}
+	});
+
+	source.addEventListener("heartbeat", function(e) {
+		var data = JSON.parse(e.data);
+		var row = findRow(data.service);
+		if (row) {
+			row.querySelector("td.heartbeat").textContent = data.last_heartbeat;
+		}
+	});
+
+	source.addEventListener("log", function(e) {
+		var data = JSON.parse(e.data);
+		var row = findRow(data.service);
+		if (!row) {
+			return;
+		}
+
+		// the totals count every occurrence, so repeats of an entry add one
+		// just like new entries
+		var cell = row.querySelector("td.notifications");
+		var counts = cell.querySelectorAll("span[data-severity]");
+		for (var i = 0; i < counts.length; i++) {
+			var severity = parseInt(counts[i].getAttribute("data-severity"), 10);
+			if (severity == data.severity) {
+				counts[i].textContent = parseInt(counts[i].textContent, 10) + 1;
+				return;
+			}
+			if (severity > data.severity) {
+				break;
+			}
+		}
+
+		var span = document.createElement("span");
+		span.setAttribute("data-severity", data.severity);
+		span.className = "event-class-" + data.severity;
+		span.title = data.severity_name;
+		if (data.color) {
+			span.style.backgroundColor = data.color;
+		}
+		span.textContent = "1";
+		cell.insertBefore(span, i < counts.length ? counts[i] : null);
+		cell.insertBefore(document.createTextNode(" "), span.nextSibling);
+	});
+})();
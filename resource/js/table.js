@@ -1,142 +1,222 @@
-YAHOO.example.DynamicData = function() {
-    var twodigits = function(x) { 
-    	return (x >= 10 ? "": "0")+x;
-    }
+// The events tables on the list-events and events pages.  Rows are loaded a
+// page at a time from list-events-data, following the cursor each page
+// returns; clicking a heading sorts on that column, and clicking a row
+// selects it for "Clear selected".
+(function() {
+	var pageSize = 50;
+	// the cursor of each page up to the current one; the first is ""
+	var cursors = [""];
+	var sort = "id";
+	var dir = "desc";
+	var selected = {};
 
-	var myFormatDateTime = function (elCell, oRecord, oColumn, oData) {
-		elCell.innerHTML = oData.getFullYear() + "/" + twodigits(oData.getMonth()+1) + "/" + twodigits(oData.getDate()) + " " + twodigits(oData.getHours()) + ":" + twodigits(oData.getMinutes()) + ":" + twodigits(oData.getSeconds());
+	var twodigits = function(x) {
+		return (x >= 10 ? "": "0")+x;
+	};
+
+	var formatDateTime = function(s) {
+		var d = new Date(s);
+		return d.getFullYear() + "/" + twodigits(d.getMonth()+1) + "/" + twodigits(d.getDate()) + " " + twodigits(d.getHours()) + ":" + twodigits(d.getMinutes()) + ":" + twodigits(d.getSeconds());
+	};
+
+	var link = function(href, text) {
+		var a = document.createElement("a");
+		a.href = href;
+		a.textContent = text;
+		return a;
+	};
+
+	// each column's heading, sort key and how to fill its cell from a record
+	var columns = [
+		{label: "ID", key: "id", cell: function(td, r) { td.appendChild(link("event?id=" + r.id, r.id)); }},
+		{label: "Severity", key: "severity", cell: function(td, r) { td.textContent = r.severity_name; }},
+		{label: "Service", key: "service", cell: function(td, r) { td.appendChild(link("service?name=" + encodeURIComponent(r.service), r.service)); }},
+		{label: "Summary", key: "summary", cell: function(td, r) { td.textContent = r.summary; }},
+		{label: "Count", key: "count", cell: function(td, r) { td.textContent = r.count > 1 ? "x" + r.count : ""; }},
+		{label: "First seen", key: "timestamp", cell: function(td, r) { td.textContent = formatDateTime(r.timestamp); }},
+		{label: "Last seen", key: "last_seen", cell: function(td, r) { td.textContent = formatDateTime(r.last_seen); }}
+	];
+
+	// the list-events page is for one service
+	var getServiceId = function() {
+		var input = document.getElementById("service_id");
+		return input ? input.value : "";
+	};
+
+	// the filters: the service, or the events page's form
+	var getFilters = function() {
+		var form = document.getElementById("event-filters");
+		var params = form ? new URLSearchParams(new FormData(form)) : new URLSearchParams();
+		if (getServiceId() != "") {
+			params.set("service", getServiceId());
+		}
+		return params;
+	};
+
+	var post = function(url, body, done) {
+		var req = new XMLHttpRequest();
+		req.open("POST", url);
+		req.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+		req.onload = done;
+		req.onerror = function() { console.log("failure"); };
+		req.send(body);
+	};
+
+	var restart = function() {
+		cursors = [""];
+		refreshTable();
+	};
+
+	var render = function(data) {
+		var container = document.getElementById("dynamicdata");
+		var table = document.createElement("table");
+		table.className = "events";
+
+		var head = table.insertRow(-1);
+		columns.forEach(function(column) {
+			var th = document.createElement("th");
+			th.textContent = column.label + (column.key == sort ? (dir == "asc" ? " ▲" : " ▼") : "");
+			th.className = "sortable";
+			th.onclick = function() {
+				if (sort == column.key) {
+					dir = dir == "asc" ? "desc" : "asc";
+				} else {
+					sort = column.key;
+					dir = "asc";
+				}
+				restart();
+			};
+			head.appendChild(th);
+		});
+
+		for (var j = 0; j < data.records.length; j++) {
+			var record = data.records[j];
+			var row = table.insertRow(-1);
+			row.setAttribute("data-id", record.id);
+			if (selected[record.id]) {
+				row.className = "selected";
+			}
+			for (var k = 0; k < columns.length; k++) {
+				columns[k].cell(row.insertCell(-1), record);
+			}
+		}
+
+		table.addEventListener("click", function(e) {
+			if (e.target.tagName == "A") {
+				return;
+			}
+			var row = e.target.closest("tr[data-id]");
+			if (!row) {
+				return;
+			}
+			var id = row.getAttribute("data-id");
+			if (selected[id]) {
+				delete selected[id];
+				row.className = "";
+			} else {
+				selected[id] = true;
+				row.className = "selected";
+			}
+		});
+
+		var pager = document.createElement("div");
+		pager.className = "pager";
+		var previous = document.createElement("input");
+		previous.type = "button";
+		previous.value = "Previous";
+		previous.disabled = cursors.length == 1;
+		previous.onclick = function() { cursors.pop(); refreshTable(); };
+		var next = document.createElement("input");
+		next.type = "button";
+		next.value = "Next";
+		next.disabled = data.next == null;
+		next.onclick = function() { cursors.push(data.next); refreshTable(); };
+		var info = document.createElement("span");
+		// the total counts from this page on
+		info.textContent = data.totalRecords == 0 && data.next == null ? "No events" :
+			"page " + cursors.length + ", " + data.totalRecords + (data.totalCapped ? "+" : "") +
+			(cursors.length == 1 ? " events" : " events from here");
+		pager.appendChild(previous);
+		pager.appendChild(info);
+		pager.appendChild(next);
+
+		container.innerHTML = "";
+		container.appendChild(table);
+		container.appendChild(pager);
+	};
+
+	var showError = function(message) {
+		var container = document.getElementById("dynamicdata");
+		container.innerHTML = "";
+		var p = document.createElement("p");
+		p.className = "root-cause";
+		p.textContent = message;
+		container.appendChild(p);
+	};
+
+	var refreshTable = function() {
+		var params = getFilters();
+		params.set("sort", sort);
+		params.set("dir", dir);
+		params.set("results", pageSize);
+		params.set("after", cursors[cursors.length - 1]);
+
+		var req = new XMLHttpRequest();
+		req.open("GET", "list-events-data?" + params.toString());
+		req.onload = function() {
+			if (req.status == 409) {
+				// the page's first entry has gone; start over
+				restart();
+				return;
+			}
+			if (req.status != 200) {
+				showError(req.responseText);
+				return;
+			}
+			var data = JSON.parse(req.responseText);
+			// the page may have emptied after clearing events
+			if (data.records.length == 0 && cursors.length > 1) {
+				cursors.pop();
+				refreshTable();
+				return;
+			}
+			render(data);
+		};
+		req.send();
+	};
+
+	var clearAll = document.getElementById("clear-all-events");
+	if (clearAll) {
+		clearAll.onclick = function() {
+			selected = {};
+			post("remove-service-events", "service=" + encodeURIComponent(getServiceId()), restart);
+		};
 	}
 
-    // Column definitions
-    var myColumnDefs = [ // sortable:true enables sorting
-        {key:"id", label:"ID", sortable:true},
-        {key:"severity", label:"Severity", sortable:true},
-        {key:"service", label:"Service", sortable:false},
-        {key:"summary", label:"Summary", sortable:true},
-        {key:"timestamp", label:"Timestamp", sortable:true, formatter:myFormatDateTime}
-    ];
-
-    // Custom parser
-    var stringToDate = function(sData) {
-        return new Date(sData);
-    };
-    
-    // DataSource instance
-    var myDataSource = new YAHOO.util.DataSource("list-events-data?");
-    myDataSource.responseType = YAHOO.util.DataSource.TYPE_JSON;
-    myDataSource.responseSchema = {
-        resultsList: "records",
-        fields: [
-            {key:"id"},
-            {key:"severity"},
-            {key:"service"},
-            {key:"summary"},
-            {key:"timestamp", parser:stringToDate}
-        ],
-        metaFields: {
-            totalRecords: "totalRecords" // Access to value in the server response
-        }
-    };
-    
-    var getServiceId = function() {
-        var service_id = YAHOO.util.Dom.get('service_id').value;
-        return service_id;
-    }
-
-    var makeEventQueryUrl = function() {
-    	return "service="+getServiceId();
-    }
-    
-    var myRequestBuilder = function(oState, oSelf) {
-        oState = oState || { pagination: null, sortedBy: null };
-        var startIndex = (oState.pagination) ? oState.pagination.recordOffset : 0;
-        var results = (oState.pagination) ? oState.pagination.rowsPerPage : 25;
-     
-        // Build custom request
-        return  "startIndex=" + startIndex +
-                "&pageSize=" + results +
-		"&"+ makeEventQueryUrl();
-    }
-    
-    // DataTable configuration
-    var myConfigs = {
-        initialRequest: makeEventQueryUrl(), // Initial request for first page of data
-        dynamicData: true, // Enables dynamic server-driven data
-        sortedBy : {key:"id", dir:YAHOO.widget.DataTable.CLASS_ASC}, // Sets UI initial sort arrow
-        paginator: new YAHOO.widget.Paginator({ rowsPerPage:50 }), // Enables pagination 
-        generateRequest: myRequestBuilder
-    };
-    
-    // DataTable instance
-    var myDataTable = new YAHOO.widget.DataTable("dynamicdata", myColumnDefs, myDataSource, myConfigs);
-    // Update totalRecords on the fly with value from server
-    myDataTable.handleDataReturnPayload = function(oRequest, oResponse, oPayload) {
-        oPayload.totalRecords = oResponse.meta.totalRecords;
-        return oPayload;
-    }
-
-    // Subscribe to events for row selection
-	myDataTable.subscribe("rowMouseoverEvent", myDataTable.onEventHighlightRow);
-	myDataTable.subscribe("rowMouseoutEvent", myDataTable.onEventUnhighlightRow);
-	myDataTable.subscribe("rowClickEvent", myDataTable.onEventSelectRow);
-
-    var refreshTable = function() {
-				var generateRequest = myDataTable.get("generateRequest");
-				var request = generateRequest(myDataTable.getState())+"&"+makeEventQueryUrl();
-				
-				var callback = {
-					success : myDataTable.onDataReturnSetRows,
-					failure : myDataTable.onDataReturnSetRows,
-					scope   : myDataTable,
-					argument: myDataTable.getState()
-					};
-				
-				myDataTable.getDataSource().sendRequest(request, callback);
-    }
-
-    var onClearAllEventsButtonClick = function(event) {
-        var callback = {
-            success : function(o) { refreshTable(); },
-            failure : function(o) { console.log("failure"); },
-            scope   : this,
-            argument: this
-            };
-
-        var postData = "service="+encodeURIComponent(getServiceId())
-        YAHOO.util.Connect.asyncRequest('POST', 'remove-service-events', callback, postData);
-        
-    }
-    
-    var onClearEventsButtonClick = function(event) {  
-    	var rowIds = myDataTable.getSelectedRows();
-    	
-    	eventIds = [];
-    	for(var i=0;i<rowIds.length;i++) {
-    		var eventId = myDataTable.getRecord(rowIds[i]).getData("id");
-    		eventIds.push("id="+encodeURIComponent(eventId));
-    	}
-
-    	var callback = {
-	        success : function(o) {  
-	        	refreshTable(); },
-	        
-    	    failure : function(o) { console.log("failure"); },
-        	scope   : this,
-        	argument: this
-		    };
-
-		var postData = eventIds.join("&");
-    	YAHOO.util.Connect.asyncRequest('POST', 'remove-events', callback, postData);
-    
-    };
-    
-    var onRefreshEventsButtonClick = function(event) {
-    	refreshTable();
-    };
-    
-    var clearAllEventsButton = new YAHOO.widget.Button("clear-all-events", { onclick: { fn: onClearAllEventsButtonClick } }); 
-    var clearEventsButton = new YAHOO.widget.Button("clear-events", { onclick: { fn: onClearEventsButtonClick } }); 
-	var refreshEventsButton = new YAHOO.widget.Button("refresh-events", { onclick: { fn: onRefreshEventsButtonClick } }); 
-	YAHOO.util.Event.addListener("service_filter", "change", refreshTable);
-    
-}();
+	document.getElementById("clear-events").onclick = function() {
+		var eventIds = [];
+		for (var id in selected) {
+			eventIds.push("id=" + encodeURIComponent(id));
+		}
+		selected = {};
+		post("remove-events", eventIds.join("&"), refreshTable);
+	};
+
+	document.getElementById("refresh-events").onclick = refreshTable;
+
+	refreshTable();
+
+	// refresh when something is logged, at most once a second
+	if (window.EventSource) {
+		var pendingRefresh = null;
+		var source = new EventSource("/events/stream" + (getServiceId() != "" ? "?service=" + encodeURIComponent(getServiceId()) : ""));
+		source.addEventListener("log", function(e) {
+			if (pendingRefresh == null) {
+				pendingRefresh = setTimeout(function() {
+					pendingRefresh = null;
+					refreshTable();
+				}, 1000);
+			}
+		});
+	}
+})();
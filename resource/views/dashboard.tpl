@@ -1,6 +1,40 @@
-{{> head}}
+{{template "head" .}}
 
+{{if .authEnabled}}
+<form action="/logout" method="POST" class="user">
+  {{.user.Name}} ({{.user.Role}})
+  <input type="submit" value="Sign out">
+</form>
+{{end}}
 <h2>Services monitored</h2>
+
+<p><a href="/incidents">Incidents</a>{{with .openIncidents}} ({{.}} unresolved){{end}} | <a href="/events">Events</a>{{if .isAdmin}} | <a href="/api-tokens">API tokens</a>{{end}}</p>
+
+<p class="summary">
+  <span class="status-up">{{.summary.Up}} up</span>
+  <span class="status-down">{{.summary.Down}} down</span>
+  <span class="status-unknown">{{.summary.Unknown}} unknown</span>
+  &middot; {{.summary.Problems}} with problems
+</p>
+
+<form action="/" method="GET">
+  <input type="text" name="q" value="{{.query.Text}}" class="span-4" placeholder="name or description">
+  <input type="text" name="selector" value="{{.selector}}" class="span-6" placeholder="team=payments,env=prod">
+  <label><input type="checkbox" name="problems" value="1"{{if .query.ProblemsOnly}} checked{{end}}> only problems</label>
+  <input type="hidden" name="sort" value="{{.query.Sort}}">
+  {{range .collapsed}}<input type="hidden" name="collapse" value="{{.}}">{{end}}
+  <input type="submit" value="Filter">
+  <a href="/">Reset</a>
+  {{with .selectorError}}<span class="root-cause">{{.}}</span>{{end}}
+</form>
+<p>
+  Sort by:
+  {{if eq .query.Sort "name"}}name{{else}}<a href="{{.sortUrls.name}}">name</a>{{end}} |
+  {{if eq .query.Sort "status"}}status{{else}}<a href="{{.sortUrls.status}}">status</a>{{end}} |
+  {{if eq .query.Sort "events"}}events{{else}}<a href="{{.sortUrls.events}}">events</a>{{end}}
+  &middot;
+  <a href="{{.problemsUrl}}">{{if .query.ProblemsOnly}}show all services{{else}}show only problems{{end}}</a>
+</p>
 <table>
   <tr>
     <th class="span-1">Status</th>
@@ -11,47 +45,83 @@
     <th>Description</th>
   </tr>
 
-{{#groups}}
-  <tr>
-  <td colspan="6">{{Group}}</td>
+{{range .groups}}
+  <tr class="group group-{{.Worst}}">
+  <td colspan="6">
+    <a href="{{.ToggleUrl}}" class="toggle">{{if .Collapsed}}&#9656;{{else}}&#9662;{{end}}</a>
+    {{with .Group}}{{.}}{{else}}(no group){{end}}
+    <span class="rollup">
+      <span class="status-{{.Worst}}">{{.Worst}}</span>
+      {{.Up}} up, {{.Down}} down, {{.Unknown}} unknown
+    </span>
+  </td>
   </tr>
 
-  {{#Services}}
-    <tr>
+  {{if not .Collapsed}}
+  {{range .Services}}
+    <tr data-service="{{.Name}}">
       <td>
-        {{#IsDown}}<img src="img/red.png">{{/IsDown}}
-        {{#IsUnknown}}<img src="img/gray.png">{{/IsUnknown}}
-        {{#IsUp}}<img src="img/green.png">{{/IsUp}}
+        {{if .IsDown}}<img class="status" src="img/red.png">{{end}}
+        {{if .IsUnknown}}<img class="status" src="img/gray.png">{{end}}
+        {{if .IsUp}}<img class="status" src="img/green.png">{{end}}
       </td>
       <td>
-        <a href="/list-events?service={{Name}}">{{Name}}</a>
+        <a href="/service?name={{.Name}}">{{.Name}}</a>
+        <div class="labels">
+        {{range .LabelList}}<span class="label">{{.Key}}={{.Value}}</span> {{end}}
+        </div>
       </td>
-      <td>
-        {{#Notifications}}
-          <span class="event-class-{{Severity}}">{{Count}}</span>
-        {{/Notifications}}
+      <td class="notifications">
+        {{range .Notifications}}
+          <span data-severity="{{.Severity}}" class="event-class-{{.Severity}}" title="{{.Name}}"{{with .Color}} style="background-color: {{.}}"{{end}}>{{.Count}}</span>
+        {{end}}
       </td>
 
       <td>
-      {{#Enabled}}
-        <img src="img/notify_on.png"><div><a href="disable-service?service={{Name}}">Disable</a></div>
-	<div>{{FilterCount}} filters</div>
-      {{/Enabled}}
-      {{^Enabled}}
-        <a href="enable-service?service={{Name}}">Enable</a>
-      {{/Enabled}}
+      {{if .Enabled}}
+        <img src="img/notify_on.png">
+        <form action="/disable-service" method="POST" class="inline"><input type="hidden" name="service" value="{{.Name}}"><input type="submit" value="Disable"></form>
+	<div>{{.FilterCount}} filters</div>
+      {{else}}
+        <form action="/enable-service" method="POST" class="inline"><input type="hidden" name="service" value="{{.Name}}"><input type="submit" value="Enable"></form>
+      {{end}}
       </td>
-      <td>
-      {{LastHeartbeatTimestamp}}
+      <td class="heartbeat">
+      {{.LastHeartbeatTimestamp}}
       </td>
       <td>
-      {{Description}}
+      {{.Description}}
+      {{with .Link}}<a href="{{.}}">more</a>{{end}}
+      {{with .RootCause}}<div class="root-cause">Root cause: {{.}} down</div>{{end}}
       </td>
     </tr>
-  {{/Services}}
-{{/groups}}
+  {{end}}
+  {{end}}
+{{end}}
 
 
 </table>
 
-{{> foot}}
+<h3>Silences</h3>
+<ul>
+{{range .silences}}
+  <li>
+  <form action="/remove-silence" method="POST">
+    <input type="submit" value="remove"/>
+    <input type="hidden" name="id" value="{{.Id}}"/>
+    {{.Selector}} until {{.Until}} {{.Comment}}
+  </form>
+  </li>
+{{end}}
+</ul>
+
+<form action="/add-silence" method="POST">
+  <input type="text" name="selector" class="span-6" placeholder="label selector">
+  for <input type="text" name="minutes" class="span-2" value="60"> minutes
+  <input type="text" name="comment" class="span-6" placeholder="comment">
+  <input type="submit" value="Add silence">
+</form>
+
+<script type="text/javascript" src="js/live.js"></script>
+
+{{template "foot" .}}
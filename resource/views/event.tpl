@@ -0,0 +1,25 @@
+{{template "head" .}}
+
+<p>
+	<a href="/list-events?service={{.service}}">Return to {{.service}}</a>
+</p>
+
+<h2>{{.service}}: {{.summary}}</h2>
+
+<table>
+	<tr><th>ID</th><td>{{.id}}</td></tr>
+	<tr><th>Severity</th><td>{{.severity}}</td></tr>
+	<tr><th>Timestamp</th><td>{{.timestamp}}</td></tr>
+{{if .repeated}}
+	<tr><th>Occurrences</th><td>x{{.count}}, last seen {{.lastSeen}}</td></tr>
+{{end}}
+{{range .fields}}
+	<tr><th>{{.Key}}</th><td>{{.Value}}</td></tr>
+{{end}}
+</table>
+
+{{with .detail}}
+<pre>{{.}}</pre>
+{{end}}
+
+{{template "foot" .}}
@@ -0,0 +1,53 @@
+{{template "head" .}}
+
+<p>
+	<a href="/">Return to dashboard</a>
+</p>
+
+<h2>Events</h2>
+
+<form action="/events" method="GET" id="event-filters">
+	<table>
+		<tr>
+			<th>Service</th><td><input type="text" name="service" value="{{.form.service}}"></td>
+			<th>Group</th><td><input type="text" name="group" value="{{.form.group}}"></td>
+		</tr>
+		<tr>
+			<th>Severity</th>
+			<td>
+				<select name="min_severity">
+					<option value="">any</option>
+					{{range .severities}}<option value="{{.Name}}"{{if eq .Name $.form.min_severity}} selected{{end}}>{{.Name}}</option>{{end}}
+				</select>
+				to
+				<select name="max_severity">
+					<option value="">any</option>
+					{{range .severities}}<option value="{{.Name}}"{{if eq .Name $.form.max_severity}} selected{{end}}>{{.Name}}</option>{{end}}
+				</select>
+			</td>
+			<th>Seen</th>
+			<td>
+				<input type="datetime-local" name="since" value="{{.form.since}}">
+				to
+				<input type="datetime-local" name="until" value="{{.form.until}}">
+			</td>
+		</tr>
+		<tr>
+			<th>Text</th><td><input type="text" name="q" value="{{.form.q}}" title="in the summary or detail"></td>
+			<th>Regexp</th><td><input type="text" name="regexp" value="{{.form.regexp}}" title="on the summary"></td>
+		</tr>
+	</table>
+	<input type="submit" value="Search">
+	<a href="/events">Clear filters</a>
+</form>
+
+<hr>
+
+<div class="span-24 last">
+<input type="button" id="clear-events" value="Clear selected">
+<input type="button" id="refresh-events" value="Refresh">
+<div id="dynamicdata"></div>
+</div>
+<script type="text/javascript" src="js/table.js"></script>
+
+{{template "foot" .}}
@@ -1,12 +1,31 @@
-%rebase basic
-<a href="/">Return to dashboard</a>
-<h1>{{description}}</h1>
-<div id="graphdiv" style="width:800px; height:320px;"></div>
-<script type="text/javascript" src="/js/graph.js"></script>
-<script type="text/javascript">
-YAHOO.util.Event.onContentReady("graphdiv", function() {
-  var g = new Dygraph(document.getElementById("graphdiv"), "/graph_data/{{name}}");
-  });
-  
-</script>
+{{template "head" .}}
 
+<p>
+	<a href="/service?name={{.service}}">Return to {{.service}}</a>
+</p>
+
+<h2>{{.service}}</h2>
+
+<form action="/graph" method="GET">
+	<input type="hidden" name="service" value="{{.service}}">
+	<select name="hours" id="graph-hours">
+	{{range .periods}}
+		<option value="{{.}}"{{if eq . $.hours}} selected{{end}}>last {{.}} hours</option>
+	{{end}}
+	</select>
+	<input type="submit" value="Show">
+	<a href="/graph_data/{{.service}}?hours={{.hours}}&amp;format=csv">CSV</a>
+	<a href="/graph_data/{{.service}}?hours={{.hours}}">JSON</a>
+</form>
+
+<input type="hidden" id="graph-service" value="{{.service}}">
+<h3>Status</h3>
+<div id="graph-status" class="graph"></div>
+<h3>Heartbeat interval</h3>
+<div id="graph-heartbeats" class="graph"></div>
+<h3>Log entries per minute</h3>
+<div id="graph-logs" class="graph"></div>
+<div id="graph-legend"></div>
+<script type="text/javascript" src="js/graph.js"></script>
+
+{{template "foot" .}}
@@ -0,0 +1,52 @@
+{{template "head" .}}
+
+<p>
+	<a href="/incidents">Return to incidents</a>
+</p>
+
+{{with .incident}}
+<h2>Incident {{.Id}}: {{.Service}}</h2>
+
+<table>
+	<tr><th>Title</th><td>{{.Title}}</td></tr>
+	<tr><th>State</th><td>{{.State}}</td></tr>
+	<tr><th>Opened</th><td>{{.Opened}}</td></tr>
+	{{if .IsResolved}}<tr><th>Resolved</th><td>{{.Resolved}}</td></tr>{{end}}
+	<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+	<tr><th>Assignee</th><td>{{.Assignee}}</td></tr>
+</table>
+
+{{if .IsOpen}}
+<form action="/acknowledge-incident" method="POST">
+	<input type="hidden" name="id" value="{{.Id}}">
+	<input type="submit" value="Acknowledge">
+</form>
+{{end}}
+{{if not .IsResolved}}
+<form action="/resolve-incident" method="POST">
+	<input type="hidden" name="id" value="{{.Id}}">
+	<input type="submit" value="Resolve">
+</form>
+{{end}}
+<form action="/assign-incident" method="POST">
+	<input type="hidden" name="id" value="{{.Id}}">
+	<input type="text" name="assignee" value="{{.Assignee}}" placeholder="assignee">
+	<input type="submit" value="Assign">
+</form>
+
+<h3>Timeline</h3>
+<ul>
+{{range .Timeline}}
+	<li>{{.Timestamp}} {{.Description}}</li>
+{{end}}
+</ul>
+
+<h3>Entries</h3>
+<p>
+{{range .Entries}}
+	<a href="/event?id={{.}}">{{.}}</a>
+{{end}}
+</p>
+{{end}}
+
+{{template "foot" .}}
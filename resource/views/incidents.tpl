@@ -0,0 +1,39 @@
+{{template "head" .}}
+
+<p>
+	<a href="/">Return to dashboard</a>
+</p>
+
+<h2>Incidents</h2>
+
+<p>
+	Show: <a href="/incidents">all</a> |
+	<a href="/incidents?state=open">open</a> |
+	<a href="/incidents?state=acknowledged">acknowledged</a> |
+	<a href="/incidents?state=resolved">resolved</a>
+</p>
+
+<table>
+  <tr>
+    <th class="span-1">ID</th>
+    <th class="span-3">Service</th>
+    <th class="span-2">State</th>
+    <th>Title</th>
+    <th class="span-3">Opened</th>
+    <th class="span-2">Duration</th>
+    <th class="span-2">Assignee</th>
+  </tr>
+{{range .incidents}}
+  <tr class="incident-{{.State}}">
+    <td><a href="/incident?id={{.Id}}">{{.Id}}</a></td>
+    <td><a href="/list-events?service={{.Service}}">{{.Service}}</a></td>
+    <td>{{.State}}</td>
+    <td>{{.Title}}</td>
+    <td>{{.Opened}}</td>
+    <td>{{.Duration}}</td>
+    <td>{{.Assignee}}</td>
+  </tr>
+{{end}}
+</table>
+
+{{template "foot" .}}
@@ -0,0 +1,16 @@
+{{template "head" .}}
+
+<h2>Sign in</h2>
+
+{{if .failed}}<p class="root-cause">Wrong name or password.</p>{{end}}
+
+<form action="/login" method="POST">
+	<input type="hidden" name="next" value="{{.next}}">
+	<table>
+		<tr><th>Name</th><td><input type="text" name="name" autofocus></td></tr>
+		<tr><th>Password</th><td><input type="password" name="password"></td></tr>
+	</table>
+	<input type="submit" value="Sign in">
+</form>
+
+{{template "foot" .}}
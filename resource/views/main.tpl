@@ -1,22 +0,0 @@
-<html>
- <head>
-
-<!-- Individual YUI CSS files --> 
-<link rel="stylesheet" type="text/css" href="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/paginator/assets/skins/sam/paginator.css"> 
-<link rel="stylesheet" type="text/css" href="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/datatable/assets/skins/sam/datatable.css"> 
-<!-- Individual YUI JS files --> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/yahoo-dom-event/yahoo-dom-event.js"></script> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/connection/connection-min.js"></script> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/element/element-min.js"></script> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/paginator/paginator-min.js"></script> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/datasource/datasource-min.js"></script> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/datatable/datatable-min.js"></script> 
-<script type="text/javascript" src="http://ajax.googleapis.com/ajax/libs/yui/2.8.1/build/json/json-min.js"></script> 
-
-  <title>{{title}}</title>
- </head>
- <body>
-	test
-   <div id="">
- </body>
-</html>
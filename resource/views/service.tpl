@@ -0,0 +1,64 @@
+{{template "head" .}}
+
+<p>
+	<a href="/">Return to dashboard</a>
+</p>
+
+{{with .service}}
+<h2>{{.Name}}</h2>
+
+<table>
+	<tr><th>Status</th><td>{{.Status}}{{if not .Enabled}} (notifications disabled){{end}}</td></tr>
+	{{with .Group}}<tr><th>Group</th><td>{{.}}</td></tr>{{end}}
+	<tr><th>Description</th><td>{{.Description}}</td></tr>
+	{{with .Link}}<tr><th>Link</th><td><a href="{{.}}">{{.}}</a></td></tr>{{end}}
+	<tr><th>Heartbeat timeout</th><td>{{.Timeout}}</td></tr>
+	<tr><th>Last heartbeat</th><td>{{with .LastHeartbeat}}{{.}} ({{$.service.SinceHeartbeat}} ago){{else}}never{{end}}</td></tr>
+	{{with .DependsOn}}<tr><th>Depends on</th><td>{{range .}}<a href="/service?name={{.}}">{{.}}</a> {{end}}</td></tr>{{end}}
+	{{with .RootCause}}<tr><th>Root cause</th><td class="root-cause">{{.}} down</td></tr>{{end}}
+	{{with .Labels}}<tr><th>Labels</th><td>{{range .}}<span class="label">{{.Key}}={{.Value}}</span> {{end}}</td></tr>{{end}}
+</table>
+
+<p>
+	<a href="/list-events?service={{.Name}}">Events and notification filters</a>
+	<a href="/graph?service={{.Name}}">Graphs</a>
+</p>
+
+<h3>Uptime</h3>
+<table>
+	<tr>{{range .Uptime}}<th>{{.Period}}</th>{{end}}</tr>
+	<tr>{{range .Uptime}}<td>{{.Uptime}}</td>{{end}}</tr>
+</table>
+
+<h3>Last 24 hours</h3>
+<div class="status-history">
+{{range .History}}
+	<div class="status-{{.Status}}" style="width: {{printf "%.3f" .Percent}}%" title="{{.Status}} from {{.Start}} for {{.Duration}}"></div>
+{{end}}
+</div>
+<ul>
+{{range .History}}
+	<li>{{.Start}}: {{.Status}} for {{.Duration}}</li>
+{{end}}
+</ul>
+
+<h3>Recent incidents</h3>
+{{with .Incidents}}
+<table>
+	<tr><th>Id</th><th>State</th><th>Title</th><th>Opened</th><th>Duration</th></tr>
+	{{range .}}
+	<tr>
+		<td><a href="/incident?id={{.Id}}">{{.Id}}</a></td>
+		<td>{{.State}}</td>
+		<td>{{.Title}}</td>
+		<td>{{.Opened}}</td>
+		<td>{{.Duration}}</td>
+	</tr>
+	{{end}}
+</table>
+{{else}}
+<p>No incidents.</p>
+{{end}}
+{{end}}
+
+{{template "foot" .}}
@@ -1,4 +1,4 @@
-{{> head}}
+{{template "head" .}}
 
 <p>
 	<a href="/">Return to dashboard</a>
@@ -6,21 +6,22 @@
 
 Filters:
 <ul>
-{{#filters}}
+{{range .filters}}
 	<li>
 	<form action="/remove-notification-filter" method="POST">
 		<input type="submit" value="remove"/>
-		<input type="hidden" name="service" value="{{service}}">
-		<input type="hidden" name="id" value="{{Id}}"/> 
+		<input type="hidden" name="service" value="{{$.service}}">
+		<input type="hidden" name="id" value="{{.Id}}"/> 
 	</form>
-	{{Expression}}
+	{{with .Field}}{{.}}: {{end}}{{.Expression}}
 	</li>
-{{/filters}}
+{{end}}
 </ul>
 
 <form action="/add-notification-filter" method="POST">
 		<input type="submit" value="Add notification filter" class="span-5">
-		<input type="hidden" name="service" value="{{service}}">
+		<input type="hidden" name="service" value="{{.service}}">
+		<input type="text" name="field" class="span-4" title="summary if empty, detail, or a field name">
 		<input type="text" name="regexp" class="span-12 last">
 </form>
 
@@ -28,7 +29,7 @@ Filters:
 
 <div class="span-24 last">
 <!-- summary <input type="text">  -->
-<input type="hidden" id="service_id" value="{{service}}">
+<input type="hidden" id="service_id" value="{{.service}}">
 <input type="button" id="clear-all-events" value="Clear all">
 <input type="button" id="clear-events" value="Clear selected">
 <input type="button" id="refresh-events" value="Refresh">
@@ -36,4 +37,4 @@ Filters:
 </div>
 <script type="text/javascript" src="js/table.js"></script>
 
-{{> foot}}
\ No newline at end of file
+{{template "foot" .}}
@@ -0,0 +1,66 @@
+{{template "head" .}}
+
+<p>
+	<a href="/">Return to dashboard</a>
+</p>
+
+<h2>API tokens</h2>
+
+{{if .error}}<p class="root-cause">{{.error}}</p>{{end}}
+{{if .created}}
+<p>
+	Token <strong>{{.created}}</strong>: <code>{{.secret}}</code><br>
+	Copy it now; it isn't shown again.  Send it to /jsonrpc as <code>Authorization: Bearer &lt;token&gt;</code>.
+</p>
+{{end}}
+
+<table>
+  <tr>
+    <th class="span-3">Name</th>
+    <th>Services</th>
+    <th>Groups</th>
+    <th>Methods</th>
+    <th class="span-2">Created</th>
+    <th class="span-1">Uses</th>
+    <th class="span-1">Denied</th>
+    <th class="span-4">Last used</th>
+    <th class="span-2"></th>
+  </tr>
+{{range .tokens}}
+  <tr>
+    <td>{{.Name}}{{if .Admin}} (admin){{end}}</td>
+    <td>{{range .Services}}{{.}} {{else}}any{{end}}</td>
+    <td>{{range .Groups}}{{.}} {{else}}any{{end}}</td>
+    <td>{{if .Admin}}all{{else}}{{range .Methods}}{{.}} {{end}}{{end}}</td>
+    <td>{{if .FromConfig}}in config{{else}}{{.Created}}{{end}}</td>
+    <td>{{.Uses}}</td>
+    <td>{{.Denied}}</td>
+    <td>{{if .LastUsed}}{{.LastUsed}}: {{.LastMethod}} from {{.LastAddress}}{{else}}never{{end}}</td>
+    <td>{{if not .FromConfig}}
+      <form action="/revoke-api-token" method="POST" class="inline">
+        <input type="hidden" name="name" value="{{.Name}}">
+        <input type="submit" value="Revoke">
+      </form>
+    {{end}}</td>
+  </tr>
+{{else}}
+  <tr><td colspan="9">No tokens.</td></tr>
+{{end}}
+</table>
+
+<h3>New token</h3>
+
+<p>Lists are comma separated.  With no services or groups the token may act on any service; with no methods it may send heartbeat and log.  Tokens made here last until a restart.</p>
+
+<form action="/create-api-token" method="POST">
+	<table>
+		<tr><th>Name</th><td><input type="text" name="name"></td></tr>
+		<tr><th>Services</th><td><input type="text" name="services"></td></tr>
+		<tr><th>Groups</th><td><input type="text" name="groups"></td></tr>
+		<tr><th>Methods</th><td><input type="text" name="methods" placeholder="heartbeat, log"></td></tr>
+		<tr><th>Admin</th><td><input type="checkbox" name="admin" value="1"> may call every method, including the token ones</td></tr>
+	</table>
+	<input type="submit" value="Create">
+</form>
+
+{{template "foot" .}}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	)
+
+// The web UI's templates, scripts, styles and images are compiled into the
+// binary so gospoke runs from a single file with no network access.  
+// Setting ResourceDir serves them from disk instead, which is handy when
+// working on the UI.
+
+//go:embed resource
+var embeddedResources embed.FS
+
+func resourceFS(resourceDir string) fs.FS {
+	if resourceDir != "" {
+		return os.DirFS(resourceDir)
+	}
+
+	resources, err := fs.Sub(embeddedResources, "resource")
+	if err != nil {
+		// only possible if the embed directive and the path disagree
+		panic(err)
+	}
+	return resources
+}
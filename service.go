@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"bytes"
+	"log"
 	"time"
 	"sort"
+	"strings"
 	"regexp"
+	"text/template"
 	)
 
 const ( 
@@ -27,24 +31,129 @@ type Service struct {
 	Enabled bool
 	Monitor *HeartbeatMonitor
 	Status int
+	// changes of Status, oldest first
+	StatusHistory []StatusChange
+	Metrics *ServiceMetrics
 	HeartbeatCount int
 	LastHeartbeatTimestamp time.Time
 	Log ServiceLog
 	Group string
 	Description string
-	// filter on summary message 
-	NotificationFilters map[int] *regexp.Regexp
+	Link string
+	// filter on summary message (or another part of the entry)
+	NotificationFilters map[int] *NotificationFilter
 	// filter on when notification was generated
 	NotificationFirstMinute int
 	NotificationLastMinute int
+	// entries at or above this severity notify.  nil means WARN.
+	NotifySeverity *int
+	// secret which must be part of /ping urls, if set
+	PingToken string
+	// names of the notification channels to send to
+	Channels []string
+	// names of the services this one needs in order to work
+	DependsOn []string
+	Labels map[string] string
+	// services listed in the config file are removed when a reload drops 
+	// them.  Services created at runtime are left alone.
+	FromConfig bool
+}
+
+// settings used to create a service
+type ServiceConfig struct {
+	Name string
+	Timeout time.Duration
+	Group string
+	Description string
+	Link string
+	Enabled bool
+	NotificationFirstMinute int
+	NotificationLastMinute int
+	NotifySeverity *int
+	PingToken string
+	Channels []string
+	DependsOn []string
+	Labels map[string] string
+}
+
+// allows services to be created on first contact instead of requiring
+// them to be listed in the config
+type AutoRegisterPolicy struct {
+	Template ServiceConfig
+	// if non-empty, the name must match one of these
+	Allow []*regexp.Regexp
+}
+
+func (p *AutoRegisterPolicy) Allows(serviceName string) bool {
+	if len(p.Allow) == 0 {
+		return true
+	}
+
+	for _, expr := range(p.Allow) {
+		if expr.MatchString(serviceName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// everything in the config file which can be applied to a running hub
+type HubConfig struct {
+	Services []*ServiceConfig
+	AutoRegister *AutoRegisterPolicy
+	NotifierCommand string
+	NotifierThrottle time.Duration
+	Channels map[string] *NotificationChannel
+	// formats the message for each entry, eg "{{.ServiceName}}: {{.Summary}} on {{.Fields.host}}"
+	NotificationTemplate *template.Template
+	Severities *SeverityTable
+	// repeats of an entry within this long are counted rather than
+	// logged.  0 turns deduplication off.
+	DedupWindow time.Duration
 }
 
 type LogEntry struct {
 	ServiceName string
 	Summary string
 	Severity int
+	// when the entry was first seen
 	Timestamp time.Time
 	Sequence int
+	// how many times the entry was seen within the dedup window, and when
+	// it was last seen
+	Count int
+	LastSeen time.Time
+	// if set, repeats are detected by this rather than the summary
+	DedupKey string
+	// optional longer text, eg a stack trace
+	Detail string
+	// optional structured data, eg host or request id
+	Fields map[string] string
+}
+
+// suppresses notifications for entries where Field matches Expression.
+// Field is "" for the summary, "detail" for the detail or else the name of
+// one of the entry's fields.
+type NotificationFilter struct {
+	Field string
+	Expression *regexp.Regexp
+}
+
+func (f *NotificationFilter) Matches(entry *LogEntry) bool {
+	var value string
+	switch f.Field {
+	case "":
+		value = entry.Summary
+	case "detail":
+		value = entry.Detail
+	default:
+		var exists bool
+		if value, exists = entry.Fields[f.Field]; !exists {
+			return false
+		}
+	}
+	return f.Expression.FindStringIndex(value) != nil
 }
 
 type ServiceLog struct {
@@ -56,6 +165,16 @@ type ServiceHub struct {
 	services map[string] *Service
 	notifier *Notifier
 	logEntryCounter int
+	autoRegister *AutoRegisterPolicy
+	silences map[int] *Silence
+	severities *SeverityTable
+	dedupWindow time.Duration
+	incidents map[int] *Incident
+	// the unresolved incident of each service that has one
+	openIncidents map[string] *Incident
+	// ids of resolved incidents, oldest resolution first
+	resolvedIncidents []int
+	events *Broadcaster
 }
 
 type ServiceSnapshot struct {
@@ -68,13 +187,24 @@ type ServiceSnapshot struct {
 	Enabled bool
 	Notifications []NotificationSummary
 	Description string
+	Link string
 	Group string
 	FilterCount int
+	// upstream services which are down, if any
+	RootCause string
+	Labels map[string] string
+	LabelList []LabelPair
+}
+
+func (s *ServiceSnapshot) Matches(selector LabelSelector) bool {
+	return selector.Matches(selectorLabels(s.Name, s.Group, s.Labels))
 }
 
 type NotificationSummary struct {
 	Severity int
 	Count int
+	Name string
+	Color string
 }
 
 type ApiError struct {
@@ -87,6 +217,7 @@ func (e ApiError) String() string {
 
 type FilterSnapshot struct {
 	Id int
+	Field string
 	Expression string
 }
 
@@ -94,28 +225,57 @@ type FilterSnapshot struct {
 // timeline thread
 type ThreadSafeServiceHub interface {
 	Log(serviceName string, summary string, severity int, timestamp time.Time) *ApiError
+	AddLogEntry(entry *LogEntry) *ApiError
 	Heartbeat(serviceName string) *ApiError
+	CheckPingToken(serviceName string, token string) *ApiError
 
 	GetLogEntries(serviceName string) []*LogEntry
+	GetLogEntry(sequence int) *LogEntry
+	SearchLog(q *EventQuery) (*EventPage, *ApiError)
 	RemoveLogEntry(sequence int)
 	GetServices() []ServiceSnapshot
 	GetNotificationFilters(serviceName string) []*FilterSnapshot
 
 	SetServiceEnabled(serviceName string, enabled bool)
+	RegisterService(config *ServiceConfig) *ApiError
+	// edit is called on the timeline thread with a copy of the current
+	// config, which is applied if edit returns no error
+	UpdateService(serviceName string, edit func(config *ServiceConfig) *ApiError) *ApiError
+	DeregisterService(serviceName string) *ApiError
+	ApplyConfig(conf *HubConfig) *ApiError
+
+	AddSilence(selector LabelSelector, length time.Duration, comment string) int
+	RemoveSilence(id int) *ApiError
+	GetSilences() []*SilenceSnapshot
+	GetSeverities() *SeverityTable
+
+	GetIncidents() []*IncidentSnapshot
+	GetIncident(id int) *IncidentSnapshot
+	GetServiceDetail(serviceName string) *ServiceDetail
+	GetServiceGroup(serviceName string) (string, bool)
+	GetMetrics(serviceName string, period time.Duration) []MetricsPoint
+	AcknowledgeIncident(id int, by string) *ApiError
+	ResolveIncident(id int, by string) *ApiError
+	AssignIncident(id int, assignee string, by string) *ApiError
+
+	// live events from the hub.  Unsubscribe when done.
+	Subscribe() chan *HubEvent
+	Unsubscribe(c chan *HubEvent)
+
 	RemoveNotificationFilter(serviceName string, id int)
-	AddNotificationFilter(serviceName string, expression *regexp.Regexp)
+	AddNotificationFilter(serviceName string, field string, expression *regexp.Regexp)
 }
 
 type ServiceHubAdapter struct {
 	hub *ServiceHub
 }
 
-func (a *ServiceHubAdapter) AddNotificationFilter(serviceName string, expression *regexp.Regexp) {
+func (a *ServiceHubAdapter) AddNotificationFilter(serviceName string, field string, expression *regexp.Regexp) {
 	c := make(chan *ApiError)
 	hub := a.hub
 
 	hub.timeline.Execute(func() {
-		hub.AddNotificationFilter(serviceName, expression)
+		hub.AddNotificationFilter(serviceName, field, expression)
 		c<-nil
 	})
 
@@ -145,34 +305,114 @@ func (a *ServiceHubAdapter) SetServiceEnabled(serviceName string, enabled bool)
 	<-c
 }
 
-func (a *ServiceHubAdapter)	Log(serviceName string, summary string, severity int, timestamp time.Time) *ApiError {
+func (a *ServiceHubAdapter) RegisterService(config *ServiceConfig) *ApiError {
 	c := make(chan *ApiError)
 	hub := a.hub
 
 	hub.timeline.Execute(func() {
-		c <- hub.Log(serviceName, summary, severity, timestamp)
+		if _, exists := hub.services[config.Name]; exists {
+			c <- &ApiError{"Service \""+config.Name+"\" already exists"}
+			return
+		}
+		if err := hub.checkDependencies(config); err != nil {
+			c <- err
+			return
+		}
+		hub.AddService(config)
+		c <- nil
 	})
 
 	return <-c
 }
 
-func (a *ServiceHubAdapter) Heartbeat (serviceName string) *ApiError  {
+func (a *ServiceHubAdapter) UpdateService(serviceName string, edit func(config *ServiceConfig) *ApiError) *ApiError {
 	c := make(chan *ApiError)
 	hub := a.hub
 
 	hub.timeline.Execute(func() {
 		service, found := hub.services[serviceName]
-
 		if !found {
 			c <- &ApiError{"No service named \""+serviceName+"\""}
-			return 
+			return
 		}
 
-		if service.Monitor != nil {
-			service.Monitor.Heartbeat()
+		config := service.Config()
+		if err := edit(config); err != nil {
+			c <- err
+			return
+		}
+		if err := hub.checkDependencies(config); err != nil {
+			c <- err
+			return
 		}
 
-		c <- nil
+		c <- hub.UpdateService(config)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) DeregisterService(serviceName string) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.RemoveService(serviceName)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) ApplyConfig(conf *HubConfig) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.ApplyConfig(conf)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) AddLogEntry(entry *LogEntry) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.AddLogEntry(entry)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter)	Log(serviceName string, summary string, severity int, timestamp time.Time) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.Log(serviceName, summary, severity, timestamp)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) Heartbeat (serviceName string) *ApiError  {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.Heartbeat(serviceName)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) CheckPingToken(serviceName string, token string) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.CheckPingToken(serviceName, token)
 	})
 
 	return <-c
@@ -195,7 +435,7 @@ func (a *ServiceHubAdapter) GetServices() []ServiceSnapshot {
 				if !exists {
 					c = 0
 				}
-				c += 1
+				c += l.Count
 				counts[l.Severity] = c
 			}
 
@@ -207,7 +447,7 @@ func (a *ServiceHubAdapter) GetServices() []ServiceSnapshot {
 			sort.Sort(sort.IntSlice(keys))
 
 			for _, k := range(keys) {
-				notifications = append(notifications, NotificationSummary{k, counts[k]})
+				notifications = append(notifications, NotificationSummary{k, counts[k], hub.severities.Name(k), hub.severities.Color(k)})
 			}
 
 			var timestamp string
@@ -221,8 +461,10 @@ func (a *ServiceHubAdapter) GetServices() []ServiceSnapshot {
 				v.Status, 
 				timestamp, 
 				v.Status == STATUS_UP, v.Status == STATUS_DOWN, v.Status == STATUS_UNKNOWN, 
-				v.Enabled, notifications, v.Description, v.Group, 
-				len(v.NotificationFilters) })
+				v.Enabled, notifications, v.Description, v.Link, v.Group, 
+				len(v.NotificationFilters),
+				strings.Join(hub.rootCauses(v), ", "),
+				v.Labels, sortedLabels(v.Labels) })
 		}
 		c <- ss		
 	})
@@ -243,8 +485,10 @@ func (a *ServiceHubAdapter) GetLogEntries(serviceName string) []*LogEntry {
 			return
 		}
 
+		// copies, since the hub updates the counts of repeated entries
 		for _, v := range(service.Log.entries) {
-			ss = append(ss, v)
+			entry := *v
+			ss = append(ss, &entry)
 		}
 
 		c <- ss
@@ -253,6 +497,26 @@ func (a *ServiceHubAdapter) GetLogEntries(serviceName string) []*LogEntry {
 	return <-c
 }
 
+func (a *ServiceHubAdapter) GetLogEntry(sequence int) *LogEntry {
+	c := make(chan *LogEntry)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		for _, service := range(hub.services) {
+			for _, v := range(service.Log.entries) {
+				if v.Sequence == sequence {
+					entry := *v
+					c <- &entry
+					return
+				}
+			}
+		}
+		c <- nil
+	})
+
+	return <-c
+}
+
 func (a *ServiceHubAdapter) GetNotificationFilters(serviceName string) []*FilterSnapshot {
 	c := make(chan []*FilterSnapshot)
 	hub := a.hub
@@ -267,7 +531,7 @@ func (a *ServiceHubAdapter) GetNotificationFilters(serviceName string) []*Filter
 		}
 
 		for k, v := range(service.NotificationFilters) {
-			fs = append(fs, &FilterSnapshot{k, v.String()})
+			fs = append(fs, &FilterSnapshot{k, v.Field, v.Expression.String()})
 		}
 
 		c <- fs
@@ -310,12 +574,14 @@ func NewHubAdapter(hub *ServiceHub) *ServiceHubAdapter {
 ////////////////////////////////////////////////////////////////////////
 
 func NewServiceHub(timeline *Timeline) *ServiceHub {
-	hub := &ServiceHub{timeline: timeline, services: make(map[string] *Service)}
+	hub := &ServiceHub{timeline: timeline, services: make(map[string] *Service), silences: make(map[int] *Silence),
+		incidents: make(map[int] *Incident), openIncidents: make(map[string] *Incident), severities: defaultSeverities,
+		events: NewBroadcaster()}
 	hub.logEntryCounter = 1
 	return hub
 }
 
-func (h *ServiceHub) AddNotificationFilter(serviceName string, expression *regexp.Regexp) *ApiError{
+func (h *ServiceHub) AddNotificationFilter(serviceName string, field string, expression *regexp.Regexp) *ApiError{
 	service, found := h.services[serviceName]
 
 	if !found {
@@ -324,7 +590,7 @@ func (h *ServiceHub) AddNotificationFilter(serviceName string, expression *regex
 
 	id := h.nextSequenceId()
 	
-	service.NotificationFilters[id] = expression
+	service.NotificationFilters[id] = &NotificationFilter{field, expression}
 
 	return nil
 }
@@ -353,49 +619,263 @@ func (h *ServiceHub) SetServiceEnabled(serviceName string, enabled bool) *ApiErr
 	return nil
 }
 
-func (h *ServiceHub) Log(serviceName string, summary string, severity int, timestamp time.Time) *ApiError {
+// looks up the service, registering it if it's unknown and the
+// auto-registration policy allows it
+func (h *ServiceHub) findOrRegisterService(serviceName string) (*Service, *ApiError) {
 	service, found := h.services[serviceName]
 
-	if !found {
-		return &ApiError{"No service named \""+serviceName+"\""}
+	if found {
+		return service, nil
+	}
+
+	if h.autoRegister == nil || serviceName == "" || !h.autoRegister.Allows(serviceName) {
+		return nil, &ApiError{"No service named \""+serviceName+"\""}
+	}
+
+	config := h.autoRegister.Template
+	config.Name = serviceName
+
+	log.Println("auto-registering service "+serviceName)
+	return h.AddService(&config), nil
+}
+
+func (h *ServiceHub) Heartbeat(serviceName string) *ApiError {
+	service, err := h.findOrRegisterService(serviceName)
+
+	if err != nil {
+		return err
+	}
+
+	if service.Monitor != nil {
+		service.Monitor.Heartbeat()
+	}
+
+	return nil
+}
+
+func (h *ServiceHub) CheckPingToken(serviceName string, token string) *ApiError {
+	service, err := h.findOrRegisterService(serviceName)
+
+	if err != nil {
+		return err
+	}
+
+	if service.PingToken != "" && subtle.ConstantTimeCompare([]byte(service.PingToken), []byte(token)) != 1 {
+		return &ApiError{"Invalid ping token for \""+serviceName+"\""}
 	}
 
-	seq := h.nextSequenceId()
-	service.Log.entries = append(service.Log.entries, &LogEntry{serviceName, summary, severity, timestamp, seq})
+	return nil
+}
+
+func (h *ServiceHub) Log(serviceName string, summary string, severity int, timestamp time.Time) *ApiError {
+	return h.AddLogEntry(&LogEntry{ServiceName: serviceName, Summary: summary, Severity: severity, Timestamp: timestamp})
+}
+
+// records the entry, assigning its sequence number
+func (h *ServiceHub) AddLogEntry(entry *LogEntry) *ApiError {
+	service, err := h.findOrRegisterService(entry.ServiceName)
+
+	if err != nil {
+		return err
+	}
+
+	service.Metrics.recordLog(h.timeline.Now(), entry.Severity)
+
+	if existing := service.Log.findDuplicate(entry, h.dedupWindow, service.statusChanged()); existing != nil {
+		existing.addOccurrence(entry)
+		// the incident may have been resolved by hand since
+		entry.Sequence = existing.Sequence
+		h.trackIncident(entry)
+		h.publishLogEntry(existing)
+		return nil
+	}
+
+	entry.Sequence = h.nextSequenceId()
+	entry.Count = 1
+	entry.LastSeen = entry.Timestamp
+	service.Log.entries = append(service.Log.entries, entry)
+	h.trackIncident(entry)
+	h.publishLogEntry(entry)
 	h.notifier.CheckAndSendNotifications()
 
 	return nil
 }
 
-func (h *ServiceHub) AddService(serviceName string, heartbeatTimeout time.Duration, group string, description string, enabled bool, nstart int, nstop int) {
+func (h *ServiceHub) AddService(config *ServiceConfig) *Service {
+	serviceName := config.Name
+
 	var s *Service
 	s = &Service{Name: serviceName, 
-		Enabled: enabled, 
+		Enabled: config.Enabled, 
 		Status: STATUS_UNKNOWN, 
-		Description: description, 
-		Group: group, 
-		NotificationFilters: make(map[int]*regexp.Regexp),
-		NotificationFirstMinute: nstart,
-		NotificationLastMinute: nstop }
+		Description: config.Description, 
+		Link: config.Link,
+		Group: config.Group, 
+		NotificationFilters: make(map[int]*NotificationFilter),
+		NotificationFirstMinute: config.NotificationFirstMinute,
+		NotificationLastMinute: config.NotificationLastMinute,
+		NotifySeverity: config.NotifySeverity,
+		PingToken: config.PingToken,
+		Channels: config.Channels,
+		DependsOn: config.DependsOn,
+		Labels: config.Labels,
+		Metrics: newServiceMetrics() }
 
 	heartbeatCallback := func(name string, isFailure bool) {
 		if isFailure {
+			// mark the service down first so notifications for it and the
+			// services depending on it see the failure
+			h.setStatus(s, STATUS_DOWN)
 			h.Log(serviceName, "Heartbeat failure", WARN,  h.timeline.Now())
-			s.Status = STATUS_DOWN
 		} else {
-			s.Status = STATUS_UP
+			if s.Status == STATUS_DOWN {
+				h.serviceRecovered(serviceName)
+			}
 			s.HeartbeatCount += 1
 			s.LastHeartbeatTimestamp = h.timeline.Now()
+			s.Metrics.recordHeartbeat(s.LastHeartbeatTimestamp)
+			h.setStatus(s, STATUS_UP)
+			h.publishHeartbeat(s)
 		}
 	}
 
-	s.Monitor = NewHeartbeatMonitor(h.timeline, serviceName, heartbeatTimeout, heartbeatCallback)
+	s.Monitor = NewHeartbeatMonitor(h.timeline, serviceName, config.Timeout, heartbeatCallback)
+	h.setStatus(s, STATUS_UNKNOWN)
 	
 	h.services[serviceName] = s
 	
 	s.Monitor.Start()
+
+	return s
 }
 
+// updates the settings of an existing service in place, keeping its log
+// and heartbeat state.  The name cannot be changed.
+func (h *ServiceHub) UpdateService(config *ServiceConfig) *ApiError {
+	s, found := h.services[config.Name]
+
+	if !found {
+		return &ApiError{"No service named \""+config.Name+"\""}
+	}
+
+	s.Enabled = config.Enabled
+	s.Group = config.Group
+	s.Description = config.Description
+	s.Link = config.Link
+	s.NotificationFirstMinute = config.NotificationFirstMinute
+	s.NotificationLastMinute = config.NotificationLastMinute
+	s.NotifySeverity = config.NotifySeverity
+	s.PingToken = config.PingToken
+	s.Channels = config.Channels
+	s.DependsOn = config.DependsOn
+	s.Labels = config.Labels
+
+	if s.Monitor.period != config.Timeout {
+		s.Monitor.SetPeriod(config.Timeout)
+	}
+
+	return nil
+}
+
+// removes the service and its log, cancelling any pending heartbeat timeout
+func (h *ServiceHub) RemoveService(serviceName string) *ApiError {
+	s, found := h.services[serviceName]
+
+	if !found {
+		return &ApiError{"No service named \""+serviceName+"\""}
+	}
+
+	s.Monitor.Stop()
+	delete(h.services, serviceName)
+
+	if incident := h.openIncident(serviceName); incident != nil {
+		h.resolveIncident(incident, h.timeline.Now(), "Service removed", "")
+	}
+
+	return nil
+}
+
+// brings the hub in line with conf.  New services are added, services 
+// which were loaded from a previous config but are no longer listed are 
+// removed, and the rest are updated in place so their log and heartbeat
+// state survive.
+func (h *ServiceHub) ApplyConfig(conf *HubConfig) *ApiError {
+	names := make(map[string] bool)
+	for _, config := range(conf.Services) {
+		if names[config.Name] {
+			return &ApiError{"Service \""+config.Name+"\" is defined more than once"}
+		}
+		names[config.Name] = true
+	}
+
+	// the new dependency graph is the new config plus any services 
+	// registered at runtime which it won't remove
+	dependencies := make(map[string] []string)
+	for name, s := range(h.services) {
+		if !s.FromConfig {
+			dependencies[name] = s.DependsOn
+		}
+	}
+	for _, config := range(conf.Services) {
+		dependencies[config.Name] = config.DependsOn
+	}
+	if cycle := findDependencyCycle(dependencies); cycle != nil {
+		return &ApiError{describeCycle(cycle)}
+	}
+
+	for name, s := range(h.services) {
+		if s.FromConfig && !names[name] {
+			log.Println("removing service "+name)
+			h.RemoveService(name)
+		}
+	}
+
+	for _, config := range(conf.Services) {
+		if _, exists := h.services[config.Name]; exists {
+			h.UpdateService(config)
+		} else {
+			h.AddService(config)
+		}
+		h.services[config.Name].FromConfig = true
+	}
+
+	h.autoRegister = conf.AutoRegister
+
+	if conf.Severities != nil {
+		h.severities = conf.Severities
+	}
+	h.dedupWindow = conf.DedupWindow
+
+	if h.notifier != nil {
+		h.notifier.command = conf.NotifierCommand
+		h.notifier.channels = conf.Channels
+		h.notifier.template = conf.NotificationTemplate
+		h.notifier.throttle = conf.NotifierThrottle
+	}
+
+	return nil
+}
+
+func (s *Service) selectorLabels() map[string] string {
+	return selectorLabels(s.Name, s.Group, s.Labels)
+}
+
+// returns a copy of the settings the service is currently using
+func (s *Service) Config() *ServiceConfig {
+	return &ServiceConfig{Name: s.Name,
+		Timeout: s.Monitor.period,
+		Group: s.Group,
+		Description: s.Description,
+		Link: s.Link,
+		Enabled: s.Enabled,
+		NotificationFirstMinute: s.NotificationFirstMinute,
+		NotificationLastMinute: s.NotificationLastMinute,
+		NotifySeverity: s.NotifySeverity,
+		PingToken: s.PingToken,
+		Channels: s.Channels,
+		DependsOn: s.DependsOn,
+		Labels: s.Labels}
+}
 
 func (h *ServiceHub) nextSequenceId() int {
 	h.logEntryCounter += 1
@@ -417,8 +897,22 @@ func (l *ServiceLog) FindAfter(sequence int) []*LogEntry {
 
 type ExecutorFn func (command string, input string)
 
+// services which don't list any channels notify through NotifierCommand
+const DEFAULT_CHANNEL = "default"
+
+type NotificationChannel struct {
+	Command string
+	// services matching this are sent to the channel as well as the 
+	// channels they list
+	Selector LabelSelector
+}
+
 type Notifier struct { 
 	command string
+	// channels other than the default, by name
+	channels map[string] *NotificationChannel
+	// formats each entry, if set
+	template *template.Template
 	lastCheckSeq int
 	lastSendTimestamp time.Time
 	timeline *Timeline
@@ -446,8 +940,6 @@ func (n *Notifier) CheckAndSendNotifications() {
 }
 
 func isAllowingNotifications(service *Service, entry *LogEntry ) bool {
-	summary := entry.Summary
-
 	localTime := entry.Timestamp
 	minuteOfDay := localTime.Hour() * 60 + localTime.Minute()
 	//log.Printf("minuteOfDay=%d first=%d last=%d\n", minuteOfDay, service.NotificationFirstMinute, service.NotificationLastMinute)
@@ -457,20 +949,72 @@ func isAllowingNotifications(service *Service, entry *LogEntry ) bool {
 
 	// check each filter
 	for _, filter := range(service.NotificationFilters) {
-		if filter.FindStringIndex(summary) != nil {
+		if filter.Matches(entry) {
 			return false
 		}
 	}
 
-	return service.Enabled && entry.Severity >= WARN
+	threshold := WARN
+	if service.NotifySeverity != nil {
+		threshold = *service.NotifySeverity
+	}
+
+	return service.Enabled && entry.Severity >= threshold
+}
+
+// the channels a service's notifications are sent to
+func (n *Notifier) serviceChannels(service *Service) []string {
+	channels := service.Channels
+	if len(channels) == 0 {
+		channels = []string{DEFAULT_CHANNEL}
+	}
+
+	var labels map[string] string
+	for name, channel := range(n.channels) {
+		if len(channel.Selector) == 0 {
+			continue
+		}
+		if labels == nil {
+			labels = service.selectorLabels()
+		}
+		if channel.Selector.Matches(labels) && !containsString(channels, name) {
+			channels = append(channels, name)
+		}
+	}
+
+	return channels
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range(list) {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 func (n *Notifier) sendNotificationSummary() {
 
-	// find all outstanding notifications, grouping them by service
-	msgsByService := make(map[string] []string)
+	// find all outstanding notifications, grouping them by channel and 
+	// then by service
+	msgsByChannel := make(map[string] map[string] []string)
+	addMsgs := func(service *Service, msgs []string) {
+		for _, channel := range(n.serviceChannels(service)) {
+			msgsByService, exists := msgsByChannel[channel]
+			if !exists {
+				msgsByService = make(map[string] []string)
+				msgsByChannel[channel] = msgsByService
+			}
+			msgsByService[service.Name] = append(msgsByService[service.Name], msgs...)
+		}
+	}
+
+	// notifications from services whose upstream is down, by root cause
+	suppressed := make(map[string] int)
+
 	maxSeq := 0
-	for k, v := range(n.hub.services) {
+	for _, v := range(n.hub.services) {
 		e := v.Log.FindAfter(n.lastCheckSeq)
 		if len(e) > 0 {
 			msgs := make([]string, 0, len(e))
@@ -481,30 +1025,56 @@ func (n *Notifier) sendNotificationSummary() {
 				}
 
 				// wait until the last moment to test v.Enabled so that maxSeq gets updated
-				if isAllowingNotifications(v, l) {
-					msgs = append(msgs, fmt.Sprintf("%s: %s", k, l.Summary))
+				if isAllowingNotifications(v, l) && !n.hub.isSilenced(v, n.timeline.Now()) {
+					msgs = append(msgs, n.formatEntry(l))
 				}
 			}
 
 			if len(msgs) > 0 {
-				msgsByService[k] = msgs
+				if roots := n.hub.rootCauses(v); len(roots) > 0 {
+					for _, root := range(roots) {
+						suppressed[root] += len(msgs)
+					}
+				} else {
+					addMsgs(v, msgs)
+				}
 			}
 		}
 	}
 
+	// collapse the suppressed notifications into one per failed upstream 
+	// service, sent to its channels
+	for root, count := range(suppressed) {
+		if service, exists := n.hub.services[root]; exists {
+			addMsgs(service, []string{fmt.Sprintf("%s: down, suppressed %d notifications from dependent services", root, count)})
+		}
+	}
+
 	// remember where we left off so we can identify what are new notifications	
 	if n.lastCheckSeq < maxSeq {
 		n.lastCheckSeq = maxSeq
 	}
-	
+
+	for channel, msgsByService := range(msgsByChannel) {
+		n.sendChannelSummary(n.channelCommand(channel), msgsByService)
+	}
+}
+
+func (n *Notifier) sendChannelSummary(command string, msgsByService map[string] []string) {
 	if len(msgsByService) > 1 {
 		msg := bytes.NewBufferString("Multiple services had notifications: ")
-		
-		for k, v := range(msgsByService) {
-			msg.WriteString(fmt.Sprintf("%s(%d) ", k, len(v)))
+
+		// in name order, so the message doesn't change from one run to the next
+		names := make([]string, 0, len(msgsByService))
+		for k := range(msgsByService) {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range(names) {
+			msg.WriteString(fmt.Sprintf("%s(%d) ", k, len(msgsByService[k])))
 		}
 	
-		n.sendNotification(msg.String())
+		n.sendNotification(command, msg.String())
 	} else if len(msgsByService) == 1 {
 		// get the only msg list
 		var serviceName string
@@ -517,16 +1087,37 @@ func (n *Notifier) sendNotificationSummary() {
 		if len(msgs) > 1 { 
 			// if we have multiple messages, just send the count of messages and 
 			msg := fmt.Sprintf("%s had %d notifications", serviceName, len(msgs))
-			n.sendNotification(msg)
+			n.sendNotification(command, msg)
 		} else {
 			// we must only have one message so just send that			
 			msg := msgs[0]			
-			n.sendNotification(msg)
+			n.sendNotification(command, msg)
 		}
 	}
 	// otherwise if there were no messages pending, so do nothing
 }
 
-func (n *Notifier) sendNotification( msg string ) {
-	n.executor(n.command, msg)
+// the message for a single entry, using the configured template if any
+func (n *Notifier) formatEntry(entry *LogEntry) string {
+	if n.template != nil {
+		b := new(bytes.Buffer)
+		if err := n.template.Execute(b, entry); err == nil {
+			return b.String()
+		} else {
+			log.Println("Could not format notification: "+err.Error())
+		}
+	}
+	return fmt.Sprintf("%s: %s", entry.ServiceName, entry.Summary)
+}
+
+func (n *Notifier) channelCommand(channel string) string {
+	if c, exists := n.channels[channel]; exists {
+		return c.Command
+	}
+	return n.command
+}
+
+func (n *Notifier) sendNotification(command string, msg string) {
+	n.hub.publishNotification(command, msg)
+	n.executor(command, msg)
 }
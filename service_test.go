@@ -4,12 +4,16 @@ import (
 	. "launchpad.net/gocheck"
 	"bytes"
 	"fmt"
+	"regexp"
+	"text/template"
+	"time"
 )
 
 func SetupNotifier() (result *bytes.Buffer, tl *Timeline, hub *ServiceHub) {
 	result = bytes.NewBufferString("")
 	
 	tl = NewTimeline(new(SimulatedTimer))
+	tl.timer.(*SimulatedTimer).time = time.Unix(0, 0)
 	
 	hub = NewServiceHub(tl)
 	
@@ -19,7 +23,7 @@ func SetupNotifier() (result *bytes.Buffer, tl *Timeline, hub *ServiceHub) {
 	n.hub = hub
 	n.executor = func(cmd string, input string) {
 		now := tl.Now()
-		t := fmt.Sprintf("%d:%s(%s)", now, cmd, input)
+		t := fmt.Sprintf("%d:%s(%s)", now.Unix(), cmd, input)
 		result.WriteString( t )
 	}
 	hub.notifier = n
@@ -27,45 +31,247 @@ func SetupNotifier() (result *bytes.Buffer, tl *Timeline, hub *ServiceHub) {
 	return
 }
 
+// services which notify at any time of day, throttled to one notification
+// a minute
+func setupNotifyingServices(hub *ServiceHub, names ...string) {
+	hub.notifier.throttle = 60 * time.Second
+	for _, name := range(names) {
+		hub.AddService(&ServiceConfig{Name: name, Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	}
+}
+
+// logs a warning at the given unix time
+func scheduleWarning(tl *Timeline, hub *ServiceHub, seconds int64, service string, summary string) {
+	tl.Schedule(time.Unix(seconds, 0), func() { hub.Log(service, summary, WARN, tl.Now()) } )
+}
+
 func (s *S) TestSingleNotification(c *C) {
 	result, tl, hub := SetupNotifier()
+	setupNotifyingServices(hub, "service")
 	
-	tl.Schedule(100, func() { hub.Log("service", WARN, "warn", 0) } )
-	tl.RunUntil(1000)
+	scheduleWarning(tl, hub, 100, "service", "warn")
+	tl.RunUntil(time.Unix(1000, 0))
 	
 	c.Assert(result.String(), Equals, "100:cmd(service: warn)")
 }
 
 func (s *S) TestTwoQuickNotification(c *C) {
 	result, tl, hub := SetupNotifier()
+	setupNotifyingServices(hub, "service")
 	
-	tl.Schedule(100, func() { hub.Log("service", WARN, "warn1", 0) } )
-	tl.Schedule(101, func() { hub.Log("service", WARN, "warn2", 0) } )
-	tl.RunUntil(1000)
+	scheduleWarning(tl, hub, 100, "service", "warn1")
+	scheduleWarning(tl, hub, 101, "service", "warn2")
+	tl.RunUntil(time.Unix(1000, 0))
 	
 	c.Assert(result.String(), Equals, "100:cmd(service: warn1)160:cmd(service: warn2)")
 }
 
 func (s *S) TestManyQuickNotification(c *C) {
 	result, tl, hub := SetupNotifier()
+	setupNotifyingServices(hub, "service")
 	
-	tl.Schedule(100, func() { hub.Log("service", WARN, "warn1", 0) } )
-	tl.Schedule(101, func() { hub.Log("service", WARN, "warn2", 0) } )
-	tl.Schedule(102, func() { hub.Log("service", WARN, "warn3", 0) } )
-	tl.Schedule(103, func() { hub.Log("service", WARN, "warn4", 0) } )
-	tl.RunUntil(1000)
+	scheduleWarning(tl, hub, 100, "service", "warn1")
+	scheduleWarning(tl, hub, 101, "service", "warn2")
+	scheduleWarning(tl, hub, 102, "service", "warn3")
+	scheduleWarning(tl, hub, 103, "service", "warn4")
+	tl.RunUntil(time.Unix(1000, 0))
 	
 	c.Assert(result.String(), Equals, "100:cmd(service: warn1)160:cmd(service had 3 notifications)")
 }
 
 func (s *S) TestManyQuickNotificationDifferentServices(c *C) {
 	result, tl, hub := SetupNotifier()
+	setupNotifyingServices(hub, "service", "alt1", "alt2", "alt3")
 	
-	tl.Schedule(100, func() { hub.Log("service", WARN, "warn1", 0) } )
-	tl.Schedule(101, func() { hub.Log("alt1", WARN, "warn2", 0) } )
-	tl.Schedule(102, func() { hub.Log("alt2", WARN, "warn3", 0) } )
-	tl.Schedule(103, func() { hub.Log("alt3", WARN, "warn4", 0) } )
-	tl.RunUntil(1000)
+	scheduleWarning(tl, hub, 100, "service", "warn1")
+	scheduleWarning(tl, hub, 101, "alt1", "warn2")
+	scheduleWarning(tl, hub, 102, "alt2", "warn3")
+	scheduleWarning(tl, hub, 103, "alt3", "warn4")
+	tl.RunUntil(time.Unix(1000, 0))
 	
-	c.Assert(result.String(), Equals, "100:cmd(service: warn1)160:cmd(Multiple services had notifications: alt3(1) alt2(1) alt1(1) )")
+	c.Assert(result.String(), Equals, "100:cmd(service: warn1)160:cmd(Multiple services had notifications: alt1(1) alt2(1) alt3(1) )")
+}
+
+func (s *S) TestAutoRegister(c *C) {
+	tl := NewTimeline(new(SimulatedTimer))
+	hub := NewServiceHub(tl)
+
+	c.Assert(hub.Heartbeat("cron-nightly"), NotNil)
+
+	hub.autoRegister = &AutoRegisterPolicy{Template: ServiceConfig{Group: "cron", Timeout: 60 * time.Second}, 
+		Allow: []*regexp.Regexp{regexp.MustCompile("^cron-")}}
+
+	c.Assert(hub.Heartbeat("cron-nightly"), IsNil)
+	c.Assert(hub.Heartbeat("web"), NotNil)
+
+	service := hub.services["cron-nightly"]
+	c.Assert(service.Group, Equals, "cron")
+	c.Assert(service.Status, Equals, STATUS_UP)
+	c.Assert(len(hub.services), Equals, 1)
+}
+
+func (s *S) TestUpdateAndRemoveService(c *C) {
+	result, tl, hub := SetupNotifier()
+
+	start := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = start
+	hub.AddService(&ServiceConfig{Name: "service", Timeout: 10 * time.Second, Enabled: true, NotificationLastMinute: 24*60})
+
+	config := hub.services["service"].Config()
+	config.Timeout = 30 * time.Second
+	config.Group = "moved"
+	c.Assert(hub.UpdateService(config), IsNil)
+	c.Assert(hub.services["service"].Group, Equals, "moved")
+
+	// the timeout check was rescheduled from 10s to 30s
+	tl.RunUntil(start.Add(20 * time.Second))
+	c.Assert(hub.services["service"].Status, Equals, STATUS_UNKNOWN)
+
+	c.Assert(hub.RemoveService("service"), IsNil)
+	c.Assert(hub.RemoveService("service"), NotNil)
+
+	// and removing the service cancelled it
+	tl.RunUntil(start.Add(60 * time.Second))
+	c.Assert(result.String(), Equals, "")
+	c.Assert(tl.events.Len(), Equals, 0)
+}
+
+func (s *S) TestApplyConfig(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	err := hub.ApplyConfig(&HubConfig{NotifierCommand: "cmd", Services: []*ServiceConfig{
+		&ServiceConfig{Name: "a", Timeout: 10 * time.Second},
+		&ServiceConfig{Name: "b", Timeout: 10 * time.Second}}})
+	c.Assert(err, IsNil)
+
+	hub.AddService(&ServiceConfig{Name: "registered", Timeout: 10 * time.Second})
+	hub.Log("a", "kept", INFO, now)
+
+	err = hub.ApplyConfig(&HubConfig{NotifierCommand: "cmd2", Services: []*ServiceConfig{
+		&ServiceConfig{Name: "a", Timeout: 20 * time.Second, Group: "g"},
+		&ServiceConfig{Name: "c", Timeout: 10 * time.Second}}})
+	c.Assert(err, IsNil)
+
+	c.Assert(len(hub.services), Equals, 3)
+	c.Assert(hub.services["b"], IsNil)
+	c.Assert(hub.services["registered"], NotNil)
+	c.Assert(hub.services["a"].Group, Equals, "g")
+	c.Assert(hub.services["a"].Monitor.period, Equals, 20 * time.Second)
+	c.Assert(len(hub.services["a"].Log.entries), Equals, 1)
+	c.Assert(hub.notifier.command, Equals, "cmd2")
+
+	err = hub.ApplyConfig(&HubConfig{Services: []*ServiceConfig{
+		&ServiceConfig{Name: "a"}, &ServiceConfig{Name: "a"}}})
+	c.Assert(err, NotNil)
+	c.Assert(len(hub.services), Equals, 3)
+}
+
+func (s *S) TestNotificationChannels(c *C) {
+	result, tl, hub := SetupNotifier()
+	hub.notifier.channels = map[string] *NotificationChannel{"ops": &NotificationChannel{Command: "ops-cmd"}}
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60, Channels: []string{"ops"}})
+
+	hub.Log("a", "warn", WARN, now)
+
+	c.Assert(result.String(), Matches, ".*:ops-cmd\\(a: warn\\)")
+}
+
+func (s *S) TestDependentNotificationsCollapsed(c *C) {
+	result, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+	hub.notifier.throttle = 60 * time.Second
+
+	hub.AddService(&ServiceConfig{Name: "db", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.AddService(&ServiceConfig{Name: "web", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60, DependsOn: []string{"db"}})
+	hub.services["db"].Status = STATUS_DOWN
+
+	hub.Log("web", "timeout talking to db", WARN, now)
+
+	c.Assert(result.String(), Matches, ".*:cmd\\(db: down, suppressed 1 notifications from dependent services\\)")
+}
+
+func (s *S) TestSilence(c *C) {
+	result, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+	hub.notifier.throttle = 60 * time.Second
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60, Labels: map[string] string{"team": "payments"}})
+
+	selector, _ := ParseLabelSelector("team=payments")
+	id := hub.AddSilence(selector, 30 * time.Second, "maintenance")
+	c.Assert(hub.silences[id].Created, Equals, now)
+	c.Assert(hub.silences[id].Until, Equals, now.Add(30 * time.Second))
+
+	hub.Log("a", "silenced", WARN, now)
+	c.Assert(result.String(), Equals, "")
+
+	// once the silence expires, notifications flow again
+	tl.Schedule(now.Add(90 * time.Second), func() { hub.Log("a", "loud", WARN, tl.Now()) })
+	tl.RunUntil(now.Add(100 * time.Second))
+	c.Assert(result.String(), Matches, ".*:cmd\\(a: loud\\)")
+	c.Assert(len(hub.silences), Equals, 0)
+}
+
+func (s *S) TestFieldNotificationFilter(c *C) {
+	result, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+	hub.notifier.throttle = 60 * time.Second
+	hub.notifier.template = template.Must(template.New("n").Parse("{{.ServiceName}}: {{.Summary}} on {{.Fields.host}}"))
+
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.AddNotificationFilter("a", "host", regexp.MustCompile("^staging"))
+
+	hub.AddLogEntry(&LogEntry{ServiceName: "a", Summary: "disk full", Severity: WARN, Timestamp: now, Fields: map[string] string{"host": "staging-1"}})
+	c.Assert(result.String(), Equals, "")
+
+	tl.Schedule(now.Add(90 * time.Second), func() {
+		hub.AddLogEntry(&LogEntry{ServiceName: "a", Summary: "disk full", Severity: WARN, Timestamp: tl.Now(), Fields: map[string] string{"host": "prod-1"}})
+	})
+	tl.RunUntil(now.Add(100 * time.Second))
+	c.Assert(result.String(), Matches, ".*:cmd\\(a: disk full on prod-1\\)")
+}
+
+func (s *S) TestNotifySeverity(c *C) {
+	result, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+	hub.notifier.throttle = 60 * time.Second
+
+	critical := CRITICAL
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60, NotifySeverity: &critical})
+
+	hub.Log("a", "just an error", ERROR, now)
+	c.Assert(result.String(), Equals, "")
+
+	tl.Schedule(now.Add(90 * time.Second), func() { hub.Log("a", "on fire", CRITICAL, tl.Now()) })
+	tl.RunUntil(now.Add(100 * time.Second))
+	c.Assert(result.String(), Matches, ".*:cmd\\(a: on fire\\)")
+}
+
+func (s *S) TestNotifySeverityOkay(c *C) {
+	result, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+	hub.notifier.throttle = 60 * time.Second
+
+	okay := OKAY
+	hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60, NotifySeverity: &okay})
+
+	hub.Log("a", "starting up", INFO, now)
+	c.Assert(result.String(), Matches, ".*:cmd\\(a: starting up\\)")
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	)
+
+// Severities can be given by name ("warn", "ERROR") or by number.  The
+// built-in levels can be extended in the config with levels of their own,
+// eg {"Name": "PAGE", "Level": 10, "Color": "purple"}.
+
+const (
+	CRITICAL = 5
+	FATAL = 6
+	)
+
+type SeverityLevel struct {
+	Name string
+	Level int
+	// css color used on the dashboard, or "" for the default style
+	Color string
+}
+
+var builtinSeverities = []*SeverityLevel{
+	&SeverityLevel{"OKAY", OKAY, ""},
+	&SeverityLevel{"DEBUG", DEBUG, ""},
+	&SeverityLevel{"INFO", INFO, ""},
+	&SeverityLevel{"WARN", WARN, ""},
+	&SeverityLevel{"ERROR", ERROR, ""},
+	&SeverityLevel{"CRITICAL", CRITICAL, ""},
+	&SeverityLevel{"FATAL", FATAL, ""},
+}
+
+// the known severity levels.  A table is never modified once built, so it
+// can be shared between goroutines; reloading the config replaces it.
+type SeverityTable struct {
+	levels []*SeverityLevel
+	byName map[string] *SeverityLevel
+	byLevel map[int] *SeverityLevel
+}
+
+// builds a table of the built-in levels plus custom.  A custom level may
+// reuse a built-in number (to give it a color, say) but not a built-in name.
+func NewSeverityTable(custom []*SeverityLevel) (*SeverityTable, error) {
+	t := &SeverityTable{byName: make(map[string] *SeverityLevel), byLevel: make(map[int] *SeverityLevel)}
+
+	for _, level := range(builtinSeverities) {
+		t.byName[level.Name] = level
+		t.byLevel[level.Level] = level
+	}
+
+	customNames := make(map[string] bool)
+	for _, level := range(custom) {
+		name := strings.ToUpper(level.Name)
+		if !isValidSeverityName(name) {
+			return nil, errors.New("invalid severity name \""+level.Name+"\"")
+		}
+		if level.Level < 0 {
+			return nil, errors.New("severity \""+level.Name+"\" must not have a negative Level")
+		}
+		if customNames[name] {
+			return nil, errors.New("severity \""+level.Name+"\" is defined more than once")
+		}
+		if _, exists := t.byName[name]; exists {
+			return nil, errors.New("severity \""+level.Name+"\" is built in")
+		}
+		customNames[name] = true
+
+		l := &SeverityLevel{name, level.Level, level.Color}
+		t.byName[name] = l
+		t.byLevel[l.Level] = l
+	}
+
+	for _, level := range(t.byLevel) {
+		t.levels = append(t.levels, level)
+	}
+	sort.Slice(t.levels, func(i, j int) bool { return t.levels[i].Level < t.levels[j].Level })
+
+	return t, nil
+}
+
+func isValidSeverityName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range(name) {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	// names which look like numbers would be ambiguous
+	return !(name[0] >= '0' && name[0] <= '9')
+}
+
+var defaultSeverities, _ = NewSeverityTable(nil)
+
+// converts a severity name or number to its level.  Names are not case
+// sensitive and numbers don't need to have a name.
+func (t *SeverityTable) Parse(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if level, err := strconv.Atoi(text); err == nil {
+		if level < 0 {
+			return 0, errors.New("severity must not be negative")
+		}
+		return level, nil
+	}
+	if level, exists := t.byName[strings.ToUpper(text)]; exists {
+		return level.Level, nil
+	}
+	return 0, errors.New("unknown severity \""+text+"\"")
+}
+
+// like Parse, for a value decoded from JSON
+func (t *SeverityTable) ParseValue(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 || v != float64(int(v)) {
+			return 0, errors.New("severity must be a whole number or a name")
+		}
+		return int(v), nil
+	case int:
+		return t.Parse(strconv.Itoa(v))
+	case string:
+		return t.Parse(v)
+	}
+	return 0, errors.New("severity must be a whole number or a name")
+}
+
+// the name of a level, or its number if it doesn't have one
+func (t *SeverityTable) Name(level int) string {
+	if l, exists := t.byLevel[level]; exists {
+		return l.Name
+	}
+	return strconv.Itoa(level)
+}
+
+func (t *SeverityTable) Color(level int) string {
+	if l, exists := t.byLevel[level]; exists {
+		return l.Color
+	}
+	return ""
+}
+
+// the named levels, lowest first
+func (t *SeverityTable) Levels() []*SeverityLevel {
+	return t.levels
+}
+
+func (a *ServiceHubAdapter) GetSeverities() *SeverityTable {
+	c := make(chan *SeverityTable)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.severities
+	})
+
+	return <-c
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestParseSeverity(c *C) {
+	for text, expected := range(map[string] int{"warn": WARN, "ERROR": ERROR, "Critical": CRITICAL, "fatal": FATAL, "3": WARN, "42": 42}) {
+		level, err := defaultSeverities.Parse(text)
+		c.Assert(err, IsNil)
+		c.Assert(level, Equals, expected)
+	}
+
+	for _, text := range([]string{"", "loud", "-1"}) {
+		_, err := defaultSeverities.Parse(text)
+		c.Assert(err, NotNil)
+	}
+
+	level, err := defaultSeverities.ParseValue(float64(4))
+	c.Assert(err, IsNil)
+	c.Assert(level, Equals, ERROR)
+
+	_, err = defaultSeverities.ParseValue(2.5)
+	c.Assert(err, NotNil)
+
+	_, err = defaultSeverities.ParseValue(true)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestCustomSeverities(c *C) {
+	t, err := NewSeverityTable([]*SeverityLevel{&SeverityLevel{"page", 10, "purple"}})
+	c.Assert(err, IsNil)
+
+	level, err := t.Parse("PAGE")
+	c.Assert(err, IsNil)
+	c.Assert(level, Equals, 10)
+	c.Assert(t.Name(10), Equals, "PAGE")
+	c.Assert(t.Color(10), Equals, "purple")
+	c.Assert(t.Name(WARN), Equals, "WARN")
+	c.Assert(t.Name(7), Equals, "7")
+	c.Assert(t.Levels()[len(t.Levels())-1].Name, Equals, "PAGE")
+
+	_, err = NewSeverityTable([]*SeverityLevel{&SeverityLevel{"warn", 9, ""}})
+	c.Assert(err, NotNil)
+
+	_, err = NewSeverityTable([]*SeverityLevel{&SeverityLevel{"9lives", 9, ""}})
+	c.Assert(err, NotNil)
+}
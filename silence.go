@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"time"
+	)
+
+// A silence mutes notifications from every service matching its label
+// selector until it expires.  Log entries are still recorded.
+
+type Silence struct {
+	Id int
+	Selector LabelSelector
+	Comment string
+	Created time.Time
+	Until time.Time
+}
+
+type SilenceSnapshot struct {
+	Id int
+	Selector string
+	Comment string
+	Created string
+	Until string
+}
+
+// a silence starting now by the timeline and lasting length
+func (h *ServiceHub) AddSilence(selector LabelSelector, length time.Duration, comment string) int {
+	id := h.nextSequenceId()
+	now := h.timeline.Now()
+	h.silences[id] = &Silence{id, selector, comment, now, now.Add(length)}
+	h.publishSilence(h.silences[id], "added")
+	return id
+}
+
+func (h *ServiceHub) RemoveSilence(id int) *ApiError {
+	silence, exists := h.silences[id]
+	if !exists {
+		return &ApiError{"No silence with that id"}
+	}
+	delete(h.silences, id)
+	h.publishSilence(silence, "removed")
+	return nil
+}
+
+func (h *ServiceHub) removeExpiredSilences(now time.Time) {
+	for id, silence := range(h.silences) {
+		if !now.Before(silence.Until) {
+			delete(h.silences, id)
+		}
+	}
+}
+
+func (h *ServiceHub) isSilenced(service *Service, now time.Time) bool {
+	h.removeExpiredSilences(now)
+
+	if len(h.silences) == 0 {
+		return false
+	}
+
+	labels := service.selectorLabels()
+	for _, silence := range(h.silences) {
+		if silence.Selector.Matches(labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *ServiceHubAdapter) AddSilence(selector LabelSelector, length time.Duration, comment string) int {
+	c := make(chan int)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.AddSilence(selector, length, comment)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) RemoveSilence(id int) *ApiError {
+	c := make(chan *ApiError)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		c <- hub.RemoveSilence(id)
+	})
+
+	return <-c
+}
+
+func (a *ServiceHubAdapter) GetSilences() []*SilenceSnapshot {
+	c := make(chan []*SilenceSnapshot)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		hub.removeExpiredSilences(hub.timeline.Now())
+
+		ids := make([]int, 0, len(hub.silences))
+		for id, _ := range(hub.silences) {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		ss := make([]*SilenceSnapshot, 0, len(ids))
+		for _, id := range(ids) {
+			silence := hub.silences[id]
+			ss = append(ss, &SilenceSnapshot{silence.Id, silence.Selector.String(), silence.Comment,
+				silence.Created.Format(time.Stamp), silence.Until.Format(time.Stamp)})
+		}
+
+		c <- ss
+	})
+
+	return <-c
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	)
+
+// Streams hub events to browsers as Server-Sent Events:
+//
+//   GET /events/stream              every event
+//   GET /events/stream?service=X    only events about X (and notifications)
+//
+// Each message has the event's type as its name and its data as JSON.
+
+// a comment is sent this often so that proxies don't drop idle streams
+const SSE_KEEPALIVE = 15 * time.Second
+
+type sseHandler struct {
+	hub ThreadSafeServiceHub
+}
+
+func NewSseHandler(hub ThreadSafeServiceHub) *sseHandler {
+	return &sseHandler{hub}
+}
+
+func writeSseEvent(w io.Writer, e *HubEvent) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
+
+func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	service := r.URL.Query().Get("service")
+
+	events := h.hub.Subscribe()
+	defer h.hub.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(SSE_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case e := <-events:
+			if service != "" && e.Service != "" && e.Service != service {
+				continue
+			}
+			if err := writeSseEvent(w, e); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
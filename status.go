@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	)
+
+// Each service keeps a history of its status changes, from which the
+// detail page draws the up/down timeline and works out uptime.  History
+// older than STATUS_HISTORY_LENGTH is dropped.
+
+const STATUS_HISTORY_LENGTH = 30 * 24 * time.Hour
+
+type StatusChange struct {
+	Status int
+	Since time.Time
+}
+
+// a period during which the service had one status
+type StatusInterval struct {
+	Status int
+	Start time.Time
+	End time.Time
+}
+
+// records a status change, publishing it and trimming old history
+func (h *ServiceHub) setStatus(s *Service, status int) {
+	if status == s.Status && len(s.StatusHistory) > 0 {
+		return
+	}
+
+	previous := s.Status
+	s.Status = status
+
+	now := h.timeline.Now()
+	s.Metrics.recordStatus(now, status)
+	s.StatusHistory = append(s.StatusHistory, StatusChange{status, now})
+
+	// keep the last change before the cutoff, since it says what the
+	// status was at the start of the retained history
+	cutoff := now.Add(-STATUS_HISTORY_LENGTH)
+	drop := 0
+	for drop + 1 < len(s.StatusHistory) && !s.StatusHistory[drop + 1].Since.After(cutoff) {
+		drop += 1
+	}
+	s.StatusHistory = s.StatusHistory[drop:]
+
+	if len(s.StatusHistory) > 1 {
+		h.publishStatus(s, previous)
+	}
+}
+
+// when the status last changed, or the zero time if it never has
+func (s *Service) statusChanged() time.Time {
+	if len(s.StatusHistory) == 0 {
+		return time.Time{}
+	}
+	return s.StatusHistory[len(s.StatusHistory) - 1].Since
+}
+
+// the intervals overlapping [since, now), clipped to that range
+func (s *Service) statusIntervals(since time.Time, now time.Time) []StatusInterval {
+	intervals := make([]StatusInterval, 0, len(s.StatusHistory))
+
+	for i, change := range(s.StatusHistory) {
+		end := now
+		if i + 1 < len(s.StatusHistory) {
+			end = s.StatusHistory[i + 1].Since
+		}
+		if !end.After(since) {
+			continue
+		}
+
+		start := change.Since
+		if start.Before(since) {
+			start = since
+		}
+		intervals = append(intervals, StatusInterval{change.Status, start, end})
+	}
+
+	return intervals
+}
+
+// the fraction of the time since `since` that the service was up, out of
+// the time its status was known.  ok is false if it was never known.
+func (s *Service) uptime(since time.Time, now time.Time) (fraction float64, ok bool) {
+	var up, known time.Duration
+	for _, interval := range(s.statusIntervals(since, now)) {
+		if interval.Status == STATUS_UNKNOWN {
+			continue
+		}
+		known += interval.End.Sub(interval.Start)
+		if interval.Status == STATUS_UP {
+			up += interval.End.Sub(interval.Start)
+		}
+	}
+
+	if known == 0 {
+		return 0, false
+	}
+	return float64(up) / float64(known), true
+}
+
+func formatUptime(fraction float64, ok bool) string {
+	if !ok {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f%%", fraction * 100)
+}
+
+type StatusIntervalSnapshot struct {
+	Status string
+	Start string
+	Duration string
+	// share of the timeline bar
+	Percent float64
+}
+
+type UptimeSnapshot struct {
+	Period string
+	Uptime string
+}
+
+type ServiceDetail struct {
+	Name string
+	Status string
+	Group string
+	Description string
+	Link string
+	Enabled bool
+	Timeout string
+	LastHeartbeat string
+	SinceHeartbeat string
+	Labels []LabelPair
+	DependsOn []string
+	RootCause string
+	Uptime []UptimeSnapshot
+	// the last day, oldest first
+	History []StatusIntervalSnapshot
+	Incidents []*IncidentSnapshot
+}
+
+const RECENT_INCIDENTS = 10
+
+func (h *ServiceHub) serviceDetail(s *Service) *ServiceDetail {
+	now := h.timeline.Now()
+
+	detail := &ServiceDetail{Name: s.Name,
+		Status: statusName(s.Status),
+		Group: s.Group,
+		Description: s.Description,
+		Link: s.Link,
+		Enabled: s.Enabled,
+		Timeout: s.Monitor.period.String(),
+		Labels: sortedLabels(s.Labels),
+		DependsOn: s.DependsOn}
+
+	if s.HeartbeatCount > 0 {
+		detail.LastHeartbeat = s.LastHeartbeatTimestamp.Format(time.Stamp)
+		detail.SinceHeartbeat = now.Sub(s.LastHeartbeatTimestamp).Round(time.Second).String()
+	}
+
+	if roots := h.rootCauses(s); len(roots) > 0 {
+		detail.RootCause = fmt.Sprint(roots)
+	}
+
+	for _, period := range([]struct{ name string; length time.Duration }{
+			{"24 hours", 24 * time.Hour},
+			{"7 days", 7 * 24 * time.Hour},
+			{"30 days", 30 * 24 * time.Hour}}) {
+		detail.Uptime = append(detail.Uptime, UptimeSnapshot{period.name, formatUptime(s.uptime(now.Add(-period.length), now))})
+	}
+
+	day := now.Add(-24 * time.Hour)
+	for _, interval := range(s.statusIntervals(day, now)) {
+		length := interval.End.Sub(interval.Start)
+		detail.History = append(detail.History, StatusIntervalSnapshot{statusName(interval.Status),
+			interval.Start.Format(time.Stamp),
+			length.Round(time.Second).String(),
+			100 * float64(length) / float64(24 * time.Hour)})
+	}
+
+	ids := make([]int, 0)
+	for id, incident := range(h.incidents) {
+		if incident.ServiceName == s.Name {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	if len(ids) > RECENT_INCIDENTS {
+		ids = ids[:RECENT_INCIDENTS]
+	}
+	for _, id := range(ids) {
+		detail.Incidents = append(detail.Incidents, h.incidents[id].snapshot(now))
+	}
+
+	return detail
+}
+
+func (a *ServiceHubAdapter) GetServiceDetail(serviceName string) *ServiceDetail {
+	c := make(chan *ServiceDetail)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		s, found := hub.services[serviceName]
+		if !found {
+			c <- nil
+			return
+		}
+		c <- hub.serviceDetail(s)
+	})
+
+	return <-c
+}
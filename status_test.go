@@ -0,0 +1,68 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestStatusHistory(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	service := hub.AddService(&ServiceConfig{Name: "a", Timeout: 10 * time.Second, Enabled: true, NotificationLastMinute: 24*60})
+	c.Assert(len(service.StatusHistory), Equals, 1)
+	_, ok := service.uptime(now.Add(-time.Hour), now)
+	c.Assert(ok, Equals, false)
+
+	// up for 20s, down for 10s, then up again
+	tl.timer.(*SimulatedTimer).time = now.Add(10 * time.Second)
+	hub.Heartbeat("a")
+	tl.timer.(*SimulatedTimer).time = now.Add(20 * time.Second)
+	hub.Heartbeat("a")
+	tl.RunUntil(now.Add(40 * time.Second))
+	c.Assert(service.Status, Equals, STATUS_DOWN)
+	tl.timer.(*SimulatedTimer).time = now.Add(40 * time.Second)
+	hub.Heartbeat("a")
+
+	c.Assert(len(service.StatusHistory), Equals, 4)
+
+	end := now.Add(50 * time.Second)
+	intervals := service.statusIntervals(now, end)
+	c.Assert(len(intervals), Equals, 4)
+	c.Assert(intervals[0], Equals, StatusInterval{STATUS_UNKNOWN, now, now.Add(10 * time.Second)})
+	c.Assert(intervals[2], Equals, StatusInterval{STATUS_DOWN, now.Add(30 * time.Second), now.Add(40 * time.Second)})
+
+	// the unknown period doesn't count
+	fraction, ok := service.uptime(now.Add(-time.Hour), end)
+	c.Assert(ok, Equals, true)
+	c.Assert(fraction, Equals, 0.75)
+
+	// a window starting mid-interval is clipped
+	intervals = service.statusIntervals(now.Add(35 * time.Second), end)
+	c.Assert(len(intervals), Equals, 2)
+	c.Assert(intervals[0].Start, Equals, now.Add(35 * time.Second))
+	fraction, _ = service.uptime(now.Add(35 * time.Second), end)
+	c.Assert(fraction, Equals, 2.0 / 3)
+}
+
+func (s *S) TestStatusHistoryPruned(c *C) {
+	_, tl, hub := SetupNotifier()
+
+	now := time.Unix(100, 0)
+	tl.timer.(*SimulatedTimer).time = now
+
+	service := hub.AddService(&ServiceConfig{Name: "a", Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+	hub.Heartbeat("a")
+
+	later := now.Add(STATUS_HISTORY_LENGTH + time.Hour)
+	tl.timer.(*SimulatedTimer).time = later
+	hub.setStatus(service, STATUS_DOWN)
+
+	// the UNKNOWN entry is dropped but the UP one still covers the cutoff
+	c.Assert(len(service.StatusHistory), Equals, 2)
+	c.Assert(service.StatusHistory[0].Status, Equals, STATUS_UP)
+	fraction, _ := service.uptime(later.Add(-2 * time.Hour), later.Add(time.Hour))
+	c.Assert(fraction, Equals, 2.0 / 3)
+}
@@ -0,0 +1,13 @@
+#!/usr/bin/python
+import socket, hmac, hashlib, time, os, binascii
+
+SECRET = None
+
+def sign(cmd):
+  if SECRET is None:
+    return cmd
+  cmd = "%d %s %s" % (time.time(), binascii.hexlify(os.urandom(8)), cmd)
+  return hmac.new(SECRET, cmd, hashlib.sha256).hexdigest() + " " + cmd
+
+s = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+s.sendto("\n".join([sign("hb Alpha"), sign("log Alpha 3 xxxxxx")]), ("localhost", 9199))
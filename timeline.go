@@ -14,6 +14,8 @@ type Event struct {
 	Timestamp time.Time
 	InsertSeq int
 	Callback CallbackFn
+	// position in the heap, or -1 once it has been removed
+	index int
 } 
 
 type EventSlice struct {
@@ -60,16 +62,20 @@ func (v EventSlice) Less(i, j int) bool {
 
 func (v EventSlice) Swap(i, j int) { 
 	v.events[i], v.events[j] = v.events[j], v.events[i]
+	v.events[i].index = i
+	v.events[j].index = j
 }
 
 func (v *EventSlice) Push(x interface{} ) {
 	e := x.(*Event)
+	e.index = len(v.events)
 	v.events = append(v.events, e)
 }
 
 func (v *EventSlice) Pop() interface{} {
 	x := v.events[len(v.events)-1]
 	v.events = v.events[:len(v.events)-1]
+	x.index = -1
 	return x
 }
 
@@ -80,7 +86,7 @@ func (v EventSlice) String() string {
 		if i > 0 {
 			b.WriteString(" ")
 		}
-		b.WriteString(fmt.Sprintf("%s", e))
+		b.WriteString(fmt.Sprintf("%v", e))
 	}
 	b.WriteString("]")
 
@@ -91,14 +97,32 @@ func (t Timeline) Now () time.Time {
 	return t.timer.Now()
 }
 
-func (t *Timeline) Schedule(timestamp time.Time, callback CallbackFn) {
+func (t *Timeline) Schedule(timestamp time.Time, callback CallbackFn) *Event {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	
 	seq := t.nextSeq
 	t.nextSeq += 1
-	heap.Push(t.events, &Event{timestamp, seq, callback})
+	e := &Event{timestamp, seq, callback, -1}
+	heap.Push(t.events, e)
 	t.cond.Broadcast()
+
+	return e
+}
+
+// removes an event which has not yet fired.  Returns false if the 
+// event was not found (ie: it already ran or was already cancelled)
+func (t *Timeline) Cancel(e *Event) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if e.index < 0 || e.index >= t.events.Len() || t.events.events[e.index] != e {
+		return false
+	}
+
+	heap.Remove(t.events, e.index)
+	t.cond.Broadcast()
+	return true
 }
 
 func (t *Timeline) SchedulePeriodic(timestamp time.Time, period time.Duration, endTime time.Time, callback CallbackFn) {
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"fmt"
 	"bytes"
+	"time"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -12,25 +13,32 @@ func Test(t *testing.T) { TestingT(t) }
 type S struct{}
 var _ = Suite(&S{})
 
+// a simulated timeline starting at the unix epoch
+func simulatedTimeline() *Timeline {
+	tl := NewTimeline(new(SimulatedTimer))
+	tl.timer.(*SimulatedTimer).time = time.Unix(0, 0)
+	return tl
+}
+
 func (s *S) TestBasicScheduling(c *C) {
 	fmt.Printf("Sched\n");
 
-	tl := NewTimeline(new(SimulatedTimer))
+	tl := simulatedTimeline()
 	b := bytes.NewBufferString("")
 
-	tl.Schedule(101, func() {
+	tl.Schedule(time.Unix(101, 0), func() {
 		b.WriteString("c")
 	})
 
-	tl.Schedule(100, func() {
+	tl.Schedule(time.Unix(100, 0), func() {
 		b.WriteString("a")
 	})
 	
-	tl.Schedule(100, func() {
+	tl.Schedule(time.Unix(100, 0), func() {
 		b.WriteString("b")
 	})
 
-	tl.Schedule(103, func() {
+	tl.Schedule(time.Unix(103, 0), func() {
 		b.WriteString("d")
 	})
 	
@@ -50,10 +58,10 @@ func (s *S) TestBasicScheduling(c *C) {
 }
 
 func (s *S) TestSchedulePeriodic(c *C) {
-	tl := NewTimeline(new(SimulatedTimer))
+	tl := simulatedTimeline()
 	b := bytes.NewBufferString("")
 
-	tl.SchedulePeriodic(100, 10, 125, func() {
+	tl.SchedulePeriodic(time.Unix(100, 0), 10 * time.Second, time.Unix(125, 0), func() {
 		b.WriteString("b")
 	})
 
@@ -68,3 +76,45 @@ func (s *S) TestSchedulePeriodic(c *C) {
 	
 	c.Assert("bbb", Equals, b.String())
 }
+
+func (s *S) TestCancel(c *C) {
+	tl := NewTimeline(new(SimulatedTimer))
+	b := bytes.NewBufferString("")
+
+	start := time.Unix(100, 0)
+	tl.Schedule(start, func() { b.WriteString("a") })
+	e := tl.Schedule(start.Add(1), func() { b.WriteString("b") })
+	tl.Schedule(start.Add(2), func() { b.WriteString("c") })
+
+	c.Assert(tl.Cancel(e), Equals, true)
+	c.Assert(tl.Cancel(e), Equals, false)
+
+	tl.RunUntil(start.Add(10))
+
+	c.Assert(b.String(), Equals, "ac")
+}
+
+func (s *S) TestCancelKeepsOrder(c *C) {
+	tl := NewTimeline(new(SimulatedTimer))
+	b := bytes.NewBufferString("")
+
+	start := time.Unix(100, 0)
+	events := make([]*Event, 0)
+	for i := range(10) {
+		letter := string(rune('a' + i))
+		// scheduled out of order, so the heap has to move them about
+		events = append(events, tl.Schedule(start.Add(time.Duration((i * 7) % 10)), func() { b.WriteString(letter) }))
+	}
+
+	c.Assert(tl.Cancel(events[3]), Equals, true)
+	c.Assert(tl.Cancel(events[8]), Equals, true)
+	c.Assert(tl.Cancel(events[0]), Equals, true)
+	tl.RunUntil(start.Add(5))
+	c.Assert(b.String(), Equals, "gjcf")
+
+	// events that already ran can't be cancelled
+	c.Assert(tl.Cancel(events[2]), Equals, false)
+	c.Assert(tl.Cancel(events[1]), Equals, true)
+	tl.RunUntil(start.Add(10))
+	c.Assert(b.String(), Equals, "gjcfeh")
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	)
+
+// API tokens for the JSON-RPC endpoint, sent as
+//
+//   Authorization: Bearer <token>
+//
+// Each token limits what its holder may do.  Methods lists the methods it
+// may call, heartbeat and log if empty.  Services and Groups list the
+// services those methods may act on, any if both are empty: the service
+// named, the service of the incident, or for silences every service the
+// selector could match, so that it has to pick out a service= or group=
+// in the token's scope.  get_services, list_events and list_incidents
+// only return what is in the token's scope, while list_silences lists
+// every silence.  An Admin token may call
+// anything, including the token methods create_token, revoke_token and
+// list_tokens.
+//
+// Tokens come from ApiTokens in the config, or are created by an admin on
+// the /api-tokens page or with create_token.  Created tokens are kept in
+// memory only and are gone after a restart, so put lasting ones in the
+// config.  Only a hash of each token is kept once it's been read.
+// Reloading the config replaces the tokens from it, and created tokens
+// carry on.
+//
+// Unless RequireApiToken is set, requests without a token may still call
+// anything but the token methods, so tokens other than Admin ones would
+// limit nothing; they need RequireApiToken.  With an Auth section, though,
+// methods which change something, other than heartbeat and log, then need
+// a signed in operator (a session cookie, or basic auth in "users" mode),
+// as the web UI's changes do.  Whoever makes a change, token or user, is
+// what the by parameter of the methods which take one is set to; callers
+// can't give it themselves.
+
+type tokenDef struct {
+	Name string `yaml:"Name"`
+	Token string `yaml:"Token"`
+	Services []string `yaml:"Services"`
+	Groups []string `yaml:"Groups"`
+	Methods []string `yaml:"Methods"`
+	Admin bool `yaml:"Admin"`
+}
+
+const MIN_TOKEN_LENGTH = 16
+
+var defaultTokenMethods = []string{"heartbeat", "log"}
+
+// methods only admin tokens may call
+var tokenAdminMethods = []string{"create_token", "revoke_token", "list_tokens"}
+
+// methods with a by parameter saying who made the change
+var byMethods = []string{"acknowledge_incident", "resolve_incident", "assign_incident", "create_token", "revoke_token"}
+
+// methods which change nothing
+var readMethods = []string{"get_services", "list_silences", "list_events", "list_incidents", "get_incident", "list_tokens"}
+
+// methods whose name parameter is the service they act on
+var serviceMethods = []string{"heartbeat", "log", "register_service", "update_service", "deregister_service"}
+
+// methods whose id parameter is the incident they act on
+var incidentMethods = []string{"get_incident", "acknowledge_incident", "resolve_incident", "assign_incident"}
+
+// methods whose results are filtered to a token's scope, which they're
+// given as their scope parameter
+var scopedMethods = []string{"get_services", "list_events", "list_incidents"}
+
+// what the scope of a token is checked against
+type tokenScopeLookup interface {
+	// the group of an existing service
+	serviceGroup(serviceName string) (string, bool)
+	incidentService(id int) (string, bool)
+	silenceSelector(id int) (LabelSelector, bool)
+}
+
+type ApiToken struct {
+	Name string
+	Services []string
+	Groups []string
+	Methods []string
+	Admin bool
+	FromConfig bool
+	Created time.Time
+
+	// calls allowed and refused, and the last one allowed
+	Uses int
+	Denied int
+	LastUsed time.Time
+	LastMethod string
+	LastAddress string
+}
+
+type TokenStore struct {
+	required bool
+	auth *Authenticator
+	// every method the endpoint has, to check tokens against
+	methods []string
+
+	mutex sync.Mutex
+	// by hash of the token
+	tokens map[string] *ApiToken
+	hashes map[string] string
+
+	// where ApiTokens is, for errors found once the methods are known
+	filename string
+	line int
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// the tokens configured by conf.ApiTokens.  Changes made with them are
+// audited through auth.
+func newTokenStore(conf *optionsDef, auth *Authenticator) (*TokenStore, error) {
+	t := &TokenStore{required: conf.RequireApiToken,
+		auth: auth,
+		tokens: make(map[string] *ApiToken),
+		hashes: make(map[string] string),
+		filename: conf.filename,
+		line: lineOfText(conf.data, "ApiTokens")}
+
+	for _, def := range(conf.ApiTokens) {
+		if len(def.Token) < MIN_TOKEN_LENGTH {
+			return nil, t.configError(fmt.Sprintf("%s: Token must be at least %d characters; see -new-token", def.Name, MIN_TOKEN_LENGTH))
+		}
+		if _, err := t.add(def, true); err != nil {
+			return nil, t.configError(err.String())
+		}
+	}
+
+	if t.required && len(t.tokens) == 0 {
+		return nil, configErrors{&configError{conf.filename, lineOfText(conf.data, "RequireApiToken"), "RequireApiToken needs at least one token in ApiTokens"}}
+	}
+	for _, def := range(conf.ApiTokens) {
+		if err := t.checkRequired(def); err != nil {
+			return nil, t.configError(err.String())
+		}
+	}
+
+	return t, nil
+}
+
+// a limited token is only worth having if calls without one are refused
+func (t *TokenStore) checkRequired(def tokenDef) *ApiError {
+	if !def.Admin && !t.required {
+		return &ApiError{def.Name+": only Admin tokens can be used without RequireApiToken, since without it calls with no token may do anything"}
+	}
+	return nil
+}
+
+func (t *TokenStore) configError(msg string) error {
+	return configErrors{&configError{t.filename, t.line, "ApiTokens: "+msg}}
+}
+
+// sets the methods tokens may name, checking the configured tokens
+// against them
+func (t *TokenStore) setMethods(methods []string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.methods = methods
+	for _, token := range(t.tokens) {
+		if err := t.checkMethods(token.Methods); err != nil {
+			return t.configError(token.Name+": "+err.String())
+		}
+	}
+	return nil
+}
+
+func (t *TokenStore) knownMethods() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.methods
+}
+
+func (t *TokenStore) isRequired() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.required
+}
+
+// switches to the tokens of a reloaded config, other, which setMethods has
+// checked.  Config tokens which haven't changed keep their usage, and
+// created tokens carry on unless the config now has one of the same name,
+// or they'd no longer be allowed.
+func (t *TokenStore) reload(other *TokenStore) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for hash, token := range(t.tokens) {
+		replacement, exists := other.tokens[hash]
+		if token.FromConfig {
+			if exists && replacement.sameGrant(token) {
+				other.tokens[hash] = token
+			}
+			continue
+		}
+
+		if _, clash := other.hashes[token.Name]; clash || exists || (!token.Admin && !other.required) {
+			continue
+		}
+		other.tokens[hash] = token
+		other.hashes[token.Name] = hash
+	}
+
+	t.required = other.required
+	t.tokens = other.tokens
+	t.hashes = other.hashes
+	t.filename = other.filename
+	t.line = other.line
+}
+
+// whether the tokens allow the same things
+func (token *ApiToken) sameGrant(other *ApiToken) bool {
+	return token.Name == other.Name && token.Admin == other.Admin &&
+		slices.Equal(token.Services, other.Services) &&
+		slices.Equal(token.Groups, other.Groups) &&
+		slices.Equal(token.Methods, other.Methods)
+}
+
+func (t *TokenStore) checkMethods(methods []string) *ApiError {
+	if t.methods == nil {
+		return nil
+	}
+	for _, method := range(methods) {
+		if containsString(tokenAdminMethods, method) {
+			return &ApiError{method+" needs an Admin token"}
+		}
+		if !containsString(t.methods, method) {
+			return &ApiError{"Unknown method \""+method+"\""}
+		}
+	}
+	return nil
+}
+
+// adds a token for def.Token, which must be held by the caller
+func (t *TokenStore) add(def tokenDef, fromConfig bool) (*ApiToken, *ApiError) {
+	if def.Name == "" {
+		return nil, &ApiError{"Every token needs a Name"}
+	}
+	if _, exists := t.hashes[def.Name]; exists {
+		return nil, &ApiError{"There is already a token named \""+def.Name+"\""}
+	}
+	hash := hashToken(def.Token)
+	if _, exists := t.tokens[hash]; exists {
+		return nil, &ApiError{def.Name+": the same Token is used twice"}
+	}
+
+	methods := def.Methods
+	if len(methods) == 0 {
+		methods = defaultTokenMethods
+	}
+	if err := t.checkMethods(methods); err != nil {
+		return nil, &ApiError{def.Name+": "+err.String()}
+	}
+
+	token := &ApiToken{Name: def.Name,
+		Services: def.Services,
+		Groups: def.Groups,
+		Methods: methods,
+		Admin: def.Admin,
+		FromConfig: fromConfig,
+		Created: time.Now()}
+	t.tokens[hash] = token
+	t.hashes[def.Name] = hash
+	return token, nil
+}
+
+// creates a token from def, ignoring def.Token, and returns its secret
+func (t *TokenStore) Create(def tokenDef) (string, *ApiError) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := t.checkRequired(def); err != nil {
+		return "", err
+	}
+	def.Token = newRandomToken()
+	if _, err := t.add(def, false); err != nil {
+		return "", err
+	}
+	return def.Token, nil
+}
+
+// removes a token created since startup; ones in the config stay until
+// they're taken out of it and the config is reloaded
+func (t *TokenStore) Revoke(name string) *ApiError {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	hash, exists := t.hashes[name]
+	if !exists {
+		return &ApiError{"No token named \""+name+"\""}
+	}
+	if t.tokens[hash].FromConfig {
+		return &ApiError{"\""+name+"\" is in the config, so remove it from there and reload the config"}
+	}
+	delete(t.tokens, hash)
+	delete(t.hashes, name)
+	return nil
+}
+
+// the token the request carries, or nil if it has none and none is
+// required.  Basic auth is a user signing in rather than a token.
+func (t *TokenStore) Authenticate(r *http.Request) (*ApiToken, *ApiError) {
+	header := r.Header.Get("Authorization")
+	if _, _, basic := r.BasicAuth(); header == "" || basic {
+		if t != nil && t.isRequired() {
+			return nil, &ApiError{"An API token is required"}
+		}
+		return nil, nil
+	}
+
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, &ApiError{"Authorization must be Bearer and a token"}
+	}
+	if t == nil {
+		return nil, &ApiError{"Unknown API token"}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	token, exists := t.tokens[hashToken(strings.TrimSpace(header[7:]))]
+	if !exists {
+		return nil, &ApiError{"Unknown API token"}
+	}
+	return token, nil
+}
+
+// whether token, or user if the request has no token, may call method with
+// params, recording the call in the token's usage.  user is nil if nobody
+// has signed in.  groupOf gives the group of an existing service.  Calls
+// allowed to methods which record who made a change have their by
+// parameter set to the token or user, and those to scopedMethods have
+// their scope parameter set to the token.
+func (t *TokenStore) Authorize(token *ApiToken, user *User, method string, params map[string] interface{}, address string, scope tokenScopeLookup) *ApiError {
+	delete(params, "by")
+	delete(params, "scope")
+
+	if token == nil {
+		if containsString(tokenAdminMethods, method) {
+			return &ApiError{method+" needs an Admin token"}
+		}
+		// agents send heartbeats and logs here as they do to /ping/ and
+		// over UDP, which signing in to the web UI doesn't change
+		agentCall := containsString(defaultTokenMethods, method)
+		if !containsString(readMethods, method) && !agentCall && !user.CanOperate() {
+			if user == nil {
+				return &ApiError{method+" needs an API token or a signed in user"}
+			}
+			return &ApiError{method+" needs the operator role"}
+		}
+		if containsString(byMethods, method) {
+			params["by"] = user.String()
+		}
+		return nil
+	}
+
+	err := token.check(method, params, scope)
+
+	t.mutex.Lock()
+	if err != nil {
+		token.Denied += 1
+	} else {
+		token.Uses += 1
+		token.LastUsed = time.Now()
+		token.LastMethod = method
+		token.LastAddress = address
+	}
+	t.mutex.Unlock()
+
+	if err == nil && containsString(byMethods, method) {
+		params["by"] = "token "+token.Name
+	}
+	if err == nil && containsString(scopedMethods, method) {
+		params["scope"] = token
+	}
+	return err
+}
+
+func (token *ApiToken) check(method string, params map[string] interface{}, scope tokenScopeLookup) *ApiError {
+	if token.Admin {
+		return nil
+	}
+	if containsString(tokenAdminMethods, method) || !containsString(token.Methods, method) {
+		return &ApiError{"Token \""+token.Name+"\" may not call "+method}
+	}
+	if len(token.Services) == 0 && len(token.Groups) == 0 {
+		return nil
+	}
+
+	switch {
+	case containsString(serviceMethods, method):
+		name, _ := params["name"].(string)
+		newGroup, hasGroup := params["group"].(string)
+		if !hasGroup || (method != "register_service" && method != "update_service") {
+			newGroup = ""
+		}
+		return token.checkService(name, newGroup, scope)
+
+	case containsString(incidentMethods, method):
+		// an unknown incident is left to the method to report
+		id, _ := params["id"].(float64)
+		if name, exists := scope.incidentService(int(id)); exists {
+			return token.checkService(name, "", scope)
+		}
+
+	case method == "add_silence":
+		selectorString, _ := params["selector"].(string)
+		selector, err := ParseLabelSelector(selectorString)
+		if err != nil || !token.coversSelector(selector) {
+			return &ApiError{"Token \""+token.Name+"\" may only silence its own services, chosen with service= or group="}
+		}
+
+	case method == "remove_silence":
+		id, _ := params["id"].(float64)
+		if selector, exists := scope.silenceSelector(int(id)); exists && !token.coversSelector(selector) {
+			return &ApiError{"Token \""+token.Name+"\" may not remove a silence of other services"}
+		}
+	}
+	return nil
+}
+
+// whether a service in group is in the token's scope.  Admin tokens and
+// those without Services or Groups cover every service.
+func (token *ApiToken) covers(serviceName string, group string) bool {
+	if token == nil || token.Admin || (len(token.Services) == 0 && len(token.Groups) == 0) {
+		return true
+	}
+	return containsString(token.Services, serviceName) || (group != "" && containsString(token.Groups, group))
+}
+
+// whether the token may act on the named service, which may also be moving
+// to newGroup
+func (token *ApiToken) checkService(name string, newGroup string, scope tokenScopeLookup) *ApiError {
+	if containsString(token.Services, name) {
+		return nil
+	}
+
+	// otherwise the service has to be in one of the groups, both before
+	// and after any change to its group
+	groups := make([]string, 0, 2)
+	if group, exists := scope.serviceGroup(name); exists {
+		groups = append(groups, group)
+	}
+	if newGroup != "" {
+		groups = append(groups, newGroup)
+	}
+	for _, group := range(groups) {
+		if !containsString(token.Groups, group) {
+			return &ApiError{"Token \""+token.Name+"\" may not act on service \""+name+"\""}
+		}
+	}
+	if len(groups) == 0 {
+		return &ApiError{"Token \""+token.Name+"\" may not act on service \""+name+"\""}
+	}
+	return nil
+}
+
+// whether a selector can only match services in the token's scope, because
+// it requires one of them by name or group
+func (token *ApiToken) coversSelector(selector LabelSelector) bool {
+	for _, req := range(selector) {
+		if req.op != "=" {
+			continue
+		}
+		if (req.key == "service" && containsString(token.Services, req.value)) || (req.key == "group" && containsString(token.Groups, req.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+type TokenSnapshot struct {
+	Name string
+	Services []string
+	Groups []string
+	Methods []string
+	Admin bool
+	FromConfig bool
+	Created string
+	Uses int
+	Denied int
+	LastUsed string
+	LastMethod string
+	LastAddress string
+}
+
+// every token and its usage, by name
+func (t *TokenStore) Snapshots() []*TokenSnapshot {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make([]*TokenSnapshot, 0, len(t.tokens))
+	for _, token := range(t.tokens) {
+		s := &TokenSnapshot{Name: token.Name,
+			Services: token.Services,
+			Groups: token.Groups,
+			Methods: token.Methods,
+			Admin: token.Admin,
+			FromConfig: token.FromConfig,
+			Created: token.Created.Format(time.Stamp),
+			Uses: token.Uses,
+			Denied: token.Denied,
+			LastMethod: token.LastMethod,
+			LastAddress: token.LastAddress}
+		if !token.LastUsed.IsZero() {
+			s.LastUsed = token.LastUsed.Format(time.Stamp)
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// a comma separated list from a form, without empty items
+func splitList(s string) []string {
+	result := make([]string, 0)
+	for _, item := range(strings.Split(s, ",")) {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// requires tokens on j's requests, and adds the token methods
+func (j *JsonRpcHandler) UseTokens(tokens *TokenStore, hub ThreadSafeServiceHub) error {
+	j.tokens = tokens
+	j.hub = hub
+
+	j.Register("create_token", func(params map[string] interface{}) interface{} {
+		values := rpcValues(params)
+		admin, _ := params["admin"].(bool)
+		by, _ := params["by"].(string)
+		def := tokenDef{Name: values.Get("name"),
+			Services: values["services"],
+			Groups: values["groups"],
+			Methods: values["methods"],
+			Admin: admin}
+
+		secret, err := tokens.Create(def)
+		if err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+		tokens.auth.Audit(&User{Name: by, Role: ROLE_ADMIN}, "create-api-token", "%s services %v groups %v methods %v admin %v", def.Name, def.Services, def.Groups, def.Methods, def.Admin)
+		return map[string] string{"name": def.Name, "token": secret}
+	})
+
+	j.Register("revoke_token", func(params map[string] interface{}) interface{} {
+		name, _ := params["name"].(string)
+		by, _ := params["by"].(string)
+		if err := tokens.Revoke(name); err != nil {
+			return makeJsonRpcError(100, err.String())
+		}
+		tokens.auth.Audit(&User{Name: by, Role: ROLE_ADMIN}, "revoke-api-token", "%s", name)
+		return true
+	})
+
+	j.Register("list_tokens", func(params map[string] interface{}) interface{} {
+		return tokens.Snapshots()
+	})
+
+	methods := make([]string, 0, len(j.registry))
+	for method := range(j.registry) {
+		methods = append(methods, method)
+	}
+	return tokens.setMethods(methods)
+}
+
+func (a *ServiceHubAdapter) GetServiceGroup(serviceName string) (string, bool) {
+	type result struct {
+		group string
+		found bool
+	}
+	c := make(chan result)
+	hub := a.hub
+
+	hub.timeline.Execute(func() {
+		s, found := hub.services[serviceName]
+		if !found {
+			c <- result{"", false}
+			return
+		}
+		c <- result{s.Group, true}
+	})
+
+	r := <-c
+	return r.group, r.found
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+func readTokenConfig(c *C, config string) (*TokenStore, error) {
+	dir, err := ioutil.TempDir("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "gospoke.json"), []byte(config), 0644)
+	conf, err := readConfig(filepath.Join(dir, "gospoke.json"))
+	c.Assert(err, IsNil)
+	auth, err := newAuthenticator(conf)
+	c.Assert(err, IsNil)
+	return newTokenStore(conf, auth)
+}
+
+const tokenConfig = `{"ApiTokens": [
+	{"Name": "ingest", "Token": "ingest-0123456789", "Services": ["db"], "Groups": ["frontend"],
+		"Methods": ["heartbeat", "log", "register_service", "update_service"]},
+	{"Name": "reader", "Token": "reader-0123456789", "Methods": ["get_services"]},
+	{"Name": "root", "Token": "root-0123456789ab", "Admin": true}]`
+
+// services in groups, with an incident and a silence for each
+type fakeScope map[string] string
+
+func (f fakeScope) serviceGroup(name string) (string, bool) {
+	group, exists := f[name]
+	return group, exists
+}
+
+var fakeIncidents = map[int] string{1: "db", 2: "cache", 3: "web"}
+
+func (f fakeScope) incidentService(id int) (string, bool) {
+	name, exists := fakeIncidents[id]
+	return name, exists
+}
+
+func (f fakeScope) silenceSelector(id int) (LabelSelector, bool) {
+	name, exists := fakeIncidents[id]
+	if !exists {
+		return nil, false
+	}
+	selector, _ := ParseLabelSelector("service="+name)
+	return selector, true
+}
+
+func (s *S) TestTokenScopes(c *C) {
+	store, err := readTokenConfig(c, tokenConfig+`, "RequireApiToken": true}`)
+	c.Assert(err, IsNil)
+
+	scope := fakeScope{"db": "infra", "cache": "infra", "web": "frontend"}
+
+	token := func(secret string) *ApiToken {
+		r := httptest.NewRequest("POST", "/jsonrpc", nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		t, err := store.Authenticate(r)
+		c.Assert(err, IsNil)
+		return t
+	}
+	// without auth, anyone without a token is an anonymous admin
+	anonymous := store.auth.User(httptest.NewRequest("POST", "/jsonrpc", nil))
+	allowed := func(t *ApiToken, method string, params map[string] interface{}) bool {
+		return store.Authorize(t, anonymous, method, params, "10.0.0.1:1234", scope) == nil
+	}
+	ingest, reader, root := token("ingest-0123456789"), token("reader-0123456789"), token("root-0123456789ab")
+
+	// by name, then by group
+	c.Assert(allowed(ingest, "heartbeat", map[string] interface{}{"name": "db"}), Equals, true)
+	c.Assert(allowed(ingest, "log", map[string] interface{}{"name": "web"}), Equals, true)
+	c.Assert(allowed(ingest, "heartbeat", map[string] interface{}{"name": "cache"}), Equals, false)
+	c.Assert(allowed(ingest, "heartbeat", map[string] interface{}{"name": "unknown"}), Equals, false)
+
+	// a service can't be moved out of, or created outside, the groups
+	c.Assert(allowed(ingest, "register_service", map[string] interface{}{"name": "api", "group": "frontend"}), Equals, true)
+	c.Assert(allowed(ingest, "register_service", map[string] interface{}{"name": "api", "group": "infra"}), Equals, false)
+	c.Assert(allowed(ingest, "update_service", map[string] interface{}{"name": "web", "group": "infra"}), Equals, false)
+	c.Assert(allowed(ingest, "get_services", map[string] interface{}{}), Equals, false)
+
+	c.Assert(allowed(reader, "get_services", map[string] interface{}{}), Equals, true)
+	c.Assert(allowed(reader, "heartbeat", map[string] interface{}{"name": "db"}), Equals, false)
+
+	c.Assert(allowed(root, "deregister_service", map[string] interface{}{"name": "cache"}), Equals, true)
+	c.Assert(allowed(ingest, "list_tokens", map[string] interface{}{}), Equals, false)
+	c.Assert(allowed(nil, "list_tokens", map[string] interface{}{}), Equals, false)
+	// calls without a token are up to the user making them
+	c.Assert(allowed(nil, "heartbeat", map[string] interface{}{"name": "cache"}), Equals, true)
+
+	snapshots := store.Snapshots()
+	c.Assert(len(snapshots), Equals, 3)
+	c.Assert(snapshots[0].Name, Equals, "ingest")
+	c.Assert(snapshots[0].Uses, Equals, 3)
+	c.Assert(snapshots[0].Denied, Equals, 6)
+	c.Assert(snapshots[0].LastMethod, Equals, "register_service")
+	c.Assert(snapshots[0].LastAddress, Equals, "10.0.0.1:1234")
+	c.Assert(snapshots[1].Methods, DeepEquals, []string{"get_services"})
+}
+
+func (s *S) TestTokenScopeSilencesAndIncidents(c *C) {
+	store, err := readTokenConfig(c, `{"RequireApiToken": true, "ApiTokens": [
+	{"Name": "oncall", "Token": "oncall-0123456789", "Services": ["db"], "Groups": ["frontend"],
+		"Methods": ["add_silence", "remove_silence", "get_incident", "resolve_incident"]}]}`)
+	c.Assert(err, IsNil)
+
+	r := httptest.NewRequest("POST", "/jsonrpc", nil)
+	r.Header.Set("Authorization", "Bearer oncall-0123456789")
+	token, _ := store.Authenticate(r)
+	scope := fakeScope{"db": "infra", "cache": "infra", "web": "frontend"}
+	allowed := func(method string, params map[string] interface{}) bool {
+		return store.Authorize(token, nil, method, params, "10.0.0.1:1234", scope) == nil
+	}
+
+	// a silence has to be confined to the token's services
+	c.Assert(allowed("add_silence", map[string] interface{}{"selector": "service=db"}), Equals, true)
+	c.Assert(allowed("add_silence", map[string] interface{}{"selector": "group=frontend,env=prod"}), Equals, true)
+	c.Assert(allowed("add_silence", map[string] interface{}{"selector": "service=cache"}), Equals, false)
+	c.Assert(allowed("add_silence", map[string] interface{}{"selector": "env=prod"}), Equals, false)
+	c.Assert(allowed("add_silence", map[string] interface{}{"selector": "service!=db"}), Equals, false)
+	c.Assert(allowed("remove_silence", map[string] interface{}{"id": 1.0}), Equals, true)
+	c.Assert(allowed("remove_silence", map[string] interface{}{"id": 2.0}), Equals, false)
+
+	// and incidents to them, by name or group
+	c.Assert(allowed("resolve_incident", map[string] interface{}{"id": 1.0}), Equals, true)
+	c.Assert(allowed("resolve_incident", map[string] interface{}{"id": 3.0}), Equals, true)
+	c.Assert(allowed("resolve_incident", map[string] interface{}{"id": 2.0}), Equals, false)
+	c.Assert(allowed("get_incident", map[string] interface{}{"id": 2.0}), Equals, false)
+}
+
+func (s *S) TestTokenScopeReads(c *C) {
+	store, err := readTokenConfig(c, `{"RequireApiToken": true, "ApiTokens": [
+	{"Name": "dashboard", "Token": "dashboard-0123456789", "Services": ["db"], "Groups": ["frontend"],
+		"Methods": ["get_services", "list_events", "list_incidents"]},
+	{"Name": "root", "Token": "root-0123456789ab", "Admin": true}]}`)
+	c.Assert(err, IsNil)
+
+	_, tl, hub := SetupNotifier()
+	now := time.Unix(1000, 0)
+	tl.timer.(*SimulatedTimer).time = now
+	for name, group := range(map[string] string{"db": "infra", "cache": "infra", "web": "frontend"}) {
+		hub.AddService(&ServiceConfig{Name: name, Group: group, Timeout: time.Hour, Enabled: true, NotificationLastMinute: 24*60})
+		hub.Log(name, name+" is failing", ERROR, now)
+	}
+	rpc := MewJsonRpcHandler(&syncHub{hub: hub}, tl)
+	c.Assert(rpc.UseTokens(store, &syncHub{hub: hub}), IsNil)
+
+	// the services named in a call's results
+	services := func(secret string, method string, params string, key string) []string {
+		body := `{"jsonrpc": "2.0", "method": "`+method+`", "params": `+params+`, "id": 1}`
+		r := httptest.NewRequest("POST", "/jsonrpc", bytes.NewBufferString(body))
+		r.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		rpc.ServeHTTP(w, r)
+
+		var response struct {
+			Result json.RawMessage
+		}
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &response), IsNil)
+		var items []map[string] interface{}
+		if method == "list_events" {
+			var page struct {
+				Records []map[string] interface{}
+			}
+			c.Assert(json.Unmarshal(response.Result, &page), IsNil)
+			items = page.Records
+		} else {
+			c.Assert(json.Unmarshal(response.Result, &items), IsNil)
+		}
+
+		names := make([]string, 0)
+		for _, item := range(items) {
+			names = append(names, item[key].(string))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	c.Assert(services("dashboard-0123456789", "get_services", `{}`, "name"), DeepEquals, []string{"db", "web"})
+	c.Assert(services("dashboard-0123456789", "list_events", `{}`, "service"), DeepEquals, []string{"db", "web"})
+	c.Assert(services("dashboard-0123456789", "list_incidents", `{}`, "Service"), DeepEquals, []string{"db", "web"})
+	// asking for other services finds nothing, and the scope can't be
+	// given by the caller
+	c.Assert(services("dashboard-0123456789", "list_events", `{"service": "cache"}`, "service"), DeepEquals, []string{})
+	c.Assert(services("dashboard-0123456789", "get_services", `{"scope": null}`, "name"), DeepEquals, []string{"db", "web"})
+
+	c.Assert(services("root-0123456789ab", "get_services", `{}`, "name"), DeepEquals, []string{"cache", "db", "web"})
+	c.Assert(services("root-0123456789ab", "list_events", `{}`, "service"), DeepEquals, []string{"cache", "db", "web"})
+	c.Assert(services("root-0123456789ab", "list_incidents", `{}`, "Service"), DeepEquals, []string{"cache", "db", "web"})
+}
+
+func (s *S) TestTokenEndpoint(c *C) {
+	store, err := readTokenConfig(c, tokenConfig+`, "RequireApiToken": true}`)
+	c.Assert(err, IsNil)
+
+	rpc := new(JsonRpcHandler)
+	rpc.Register("heartbeat", func(params map[string] interface{}) interface{} {
+		return true
+	})
+	// by is only added for the methods which take it
+	rpc.Register("register_service", func(params map[string] interface{}) interface{} { return params["by"] == nil })
+	rpc.Register("update_service", func(params map[string] interface{}) interface{} { return params["by"] == nil })
+	rpc.Register("log", func(params map[string] interface{}) interface{} { return true })
+	rpc.Register("get_services", func(params map[string] interface{}) interface{} { return true })
+	c.Assert(rpc.UseTokens(store, nil), IsNil)
+
+	call := func(secret string, method string, params string) (int, map[string] interface{}) {
+		body := `{"jsonrpc": "2.0", "method": "`+method+`", "params": `+params+`, "id": 1}`
+		r := httptest.NewRequest("POST", "/jsonrpc", bytes.NewBufferString(body))
+		if secret != "" {
+			r.Header.Set("Authorization", "Bearer "+secret)
+		}
+		w := httptest.NewRecorder()
+		rpc.ServeHTTP(w, r)
+
+		var response map[string] interface{}
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &response), IsNil)
+		return w.Code, response
+	}
+	// refused by the token, or by the method
+	failed := func(response map[string] interface{}) bool {
+		result, _ := response["result"].(map[string] interface{})
+		return response["error"] != nil || result["error"] != nil
+	}
+
+	code, _ := call("", "heartbeat", `{"name": "db"}`)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+	code, _ = call("wrong-0123456789", "heartbeat", `{"name": "db"}`)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+
+	code, response := call("ingest-0123456789", "heartbeat", `{"name": "db"}`)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(response["result"], Equals, true)
+
+	_, response = call("reader-0123456789", "heartbeat", `{"name": "db"}`)
+	c.Assert(failed(response), Equals, true)
+
+	// an admin makes a token, which works until it's revoked
+	_, response = call("root-0123456789ab", "create_token", `{"name": "db-only", "services": ["db"]}`)
+	created := response["result"].(map[string] interface{})
+	secret := created["token"].(string)
+	code, response = call(secret, "heartbeat", `{"name": "db"}`)
+	c.Assert(response["result"], Equals, true)
+	_, response = call("ingest-0123456789", "update_service", `{"name": "db", "timeout": 60}`)
+	c.Assert(response["result"], Equals, true)
+
+	_, response = call("root-0123456789ab", "revoke_token", `{"name": "db-only"}`)
+	c.Assert(response["result"], Equals, true)
+	code, _ = call(secret, "heartbeat", `{"name": "db"}`)
+	c.Assert(code, Equals, http.StatusUnauthorized)
+
+	// tokens from the config stay
+	_, response = call("root-0123456789ab", "revoke_token", `{"name": "ingest"}`)
+	c.Assert(failed(response), Equals, true)
+
+	_, response = call("root-0123456789ab", "create_token", `{"name": "bad", "methods": ["reboot"]}`)
+	c.Assert(failed(response), Equals, true)
+}
+
+func (s *S) TestTokenConfigErrors(c *C) {
+	_, err := readTokenConfig(c, `{"ApiTokens": [{"Name": "short", "Token": "abc"}]}`)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, ".*at least 16 characters.*")
+
+	_, err = readTokenConfig(c, `{"ApiTokens": [{"Name": "a", "Token": "0123456789abcdef"}, {"Name": "a", "Token": "fedcba9876543210"}]}`)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, ".*already a token named.*")
+
+	_, err = readTokenConfig(c, `{"RequireApiToken": true}`)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, ".*needs at least one token.*")
+
+	_, err = readTokenConfig(c, `{"ApiTokens": [{"Name": "a", "Token": "0123456789abcdef"}]}`)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, ".*only Admin tokens can be used without RequireApiToken.*")
+
+	store, err := readTokenConfig(c, `{"RequireApiToken": true, "ApiTokens": [{"Name": "a", "Token": "0123456789abcdef", "Methods": ["reboot"]}]}`)
+	c.Assert(err, IsNil)
+	err = MewJsonRpcHandler(nil, nil).UseTokens(store, nil)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, ".*Unknown method \"reboot\".*")
+}
+
+func (s *S) TestRpcNeedsUserWithAuth(c *C) {
+	store, err := readTokenConfig(c, `{"ApiTokens": [{"Name": "root", "Token": "root-0123456789ab", "Admin": true}]}`)
+	c.Assert(err, IsNil)
+	store.auth = setupAuth(c, `{"UsersFile":"users"}`, userLine(c, "alice", "operator", "secret")+userLine(c, "bob", "viewer", "hunter2"))
+
+	rpc := new(JsonRpcHandler)
+	for _, method := range([]string{"heartbeat", "log", "get_services"}) {
+		rpc.Register(method, func(params map[string] interface{}) interface{} { return true })
+	}
+	rpc.Register("deregister_service", func(params map[string] interface{}) interface{} { return true })
+	rpc.Register("resolve_incident", func(params map[string] interface{}) interface{} { return params["by"] })
+	c.Assert(rpc.UseTokens(store, nil), IsNil)
+
+	call := func(r *http.Request) map[string] interface{} {
+		w := httptest.NewRecorder()
+		rpc.ServeHTTP(w, r)
+		var response map[string] interface{}
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &response), IsNil)
+		return response
+	}
+	request := func(method string, params string) *http.Request {
+		body := `{"jsonrpc": "2.0", "method": "`+method+`", "params": `+params+`, "id": 1}`
+		return httptest.NewRequest("POST", "/jsonrpc", bytes.NewBufferString(body))
+	}
+
+	// reading, heartbeats and logs need nobody, other changes need an
+	// operator
+	c.Assert(call(request("get_services", `{}`))["result"], Equals, true)
+	c.Assert(call(request("heartbeat", `{"name": "db"}`))["result"], Equals, true)
+	c.Assert(call(request("log", `{"name": "db", "summary": "ok"}`))["result"], Equals, true)
+	c.Assert(call(request("deregister_service", `{"name": "db"}`))["error"], NotNil)
+	c.Assert(call(request("resolve_incident", `{"id": 1, "by": "mallory"}`))["error"], NotNil)
+
+	r := request("deregister_service", `{"name": "db"}`)
+	r.SetBasicAuth("bob", "hunter2")
+	c.Assert(call(r)["error"], NotNil)
+
+	// the user or token is who made the change, whatever by says
+	r = request("resolve_incident", `{"id": 1, "by": "mallory"}`)
+	r.SetBasicAuth("alice", "secret")
+	c.Assert(call(r)["result"], Equals, "alice")
+
+	// not from a browser on another site, though
+	r = request("resolve_incident", `{"id": 1}`)
+	r.SetBasicAuth("alice", "secret")
+	r.Header.Set("Origin", "https://evil.example")
+	c.Assert(call(r)["error"], NotNil)
+
+	r = request("resolve_incident", `{"id": 1, "by": "mallory"}`)
+	r.Header.Set("Authorization", "Bearer root-0123456789ab")
+	c.Assert(call(r)["result"], Equals, "token root")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	)
+
+// Datagram protocol for agents which can't afford an HTTP request per
+// heartbeat.  Each datagram contains one or more newline separated
+// commands:
+//
+//   hb <service>
+//   log <service> <severity> <summary...>
+//
+// where severity is a name such as "warn" or a number.
+//
+// If a secret is configured, every command must be prefixed by the unix
+// time it was sent and a nonce, and those by the hex encoded HMAC-SHA256
+// of the time, nonce and command joined by spaces, eg:
+//
+//   3f2a...9c 1700000000 8e41c07d hb Alpha
+//
+// The nonce is any word which differs between the commands an agent sends
+// within a second, such as a counter or some random hex.  Commands sent
+// more than UDP_MAX_SKEW from the hub's time are refused, as are repeats
+// of a signed command within that window, so a captured datagram can't
+// be replayed.
+//
+// There is no reply.  Malformed or unauthenticated commands are logged
+// and dropped.
+
+const (
+	UDP_MAX_DATAGRAM = 8192
+	UDP_MAX_SKEW = 60 * time.Second
+	)
+
+type udpCommand struct {
+	verb string
+	service string
+	severity int
+	summary string
+	// of a signed command, for spotting replays
+	signature string
+	sent time.Time
+}
+
+// a signature accepted by the listener, and when
+type udpSeen struct {
+	signature string
+	received time.Time
+}
+
+type UdpListener struct {
+	hub ThreadSafeServiceHub
+	timeline *Timeline
+	// replaced when the config is reloaded
+	mutex sync.Mutex
+	secret []byte
+	conn net.PacketConn
+	// signatures of the commands accepted recently, and the same in the
+	// order they arrived so the oldest can be forgotten.  Only used by
+	// Serve's goroutine.
+	seen map[string] bool
+	seenOrder []udpSeen
+}
+
+func NewUdpListener(hub ThreadSafeServiceHub, timeline *Timeline, secret string) *UdpListener {
+	u := &UdpListener{hub: hub, timeline: timeline, seen: make(map[string] bool)}
+	u.SetSecret(secret)
+	return u
+}
+
+// the secret commands must be signed with, or "" if they needn't be
+func (u *UdpListener) SetSecret(secret string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.secret = nil
+	if secret != "" {
+		u.secret = []byte(secret)
+	}
+}
+
+func signUdpCommand(secret []byte, command string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(command))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parses a line, checking its signature and time against now if there's
+// a secret
+func parseUdpCommand(line string, secret []byte, severities *SeverityTable, now time.Time) (*udpCommand, *ApiError) {
+	var signature string
+	var sent time.Time
+	if secret != nil {
+		parts := strings.SplitN(line, " ", 4)
+		if len(parts) != 4 {
+			return nil, &ApiError{"Missing signature, time or nonce"}
+		}
+		expected := signUdpCommand(secret, parts[1]+" "+parts[2]+" "+parts[3])
+		if !hmac.Equal([]byte(expected), []byte(strings.ToLower(parts[0]))) {
+			return nil, &ApiError{"Bad signature"}
+		}
+
+		seconds, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, &ApiError{"Bad time \""+parts[1]+"\""}
+		}
+		sent = time.Unix(seconds, 0)
+		if skew := now.Sub(sent); skew > UDP_MAX_SKEW || skew < -UDP_MAX_SKEW {
+			return nil, &ApiError{"Command sent at "+parts[1]+" is too far from the hub's time"}
+		}
+
+		signature = strings.ToLower(parts[0])
+		line = parts[3]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, &ApiError{"Malformed command \""+line+"\""}
+	}
+
+	switch fields[0] {
+	case "hb":
+		if len(fields) != 2 {
+			return nil, &ApiError{"Malformed heartbeat \""+line+"\""}
+		}
+		return &udpCommand{verb: "hb", service: fields[1], signature: signature, sent: sent}, nil
+	case "log":
+		if len(fields) < 4 {
+			return nil, &ApiError{"Malformed log \""+line+"\""}
+		}
+		severity, err := severities.Parse(fields[2])
+		if err != nil {
+			return nil, &ApiError{"Bad severity \""+fields[2]+"\""}
+		}
+		return &udpCommand{verb: "log", service: fields[1], severity: severity, summary: strings.Join(fields[3:], " "), signature: signature, sent: sent}, nil
+	}
+
+	return nil, &ApiError{"Unknown command \""+fields[0]+"\""}
+}
+
+func (u *UdpListener) execute(cmd *udpCommand) *ApiError {
+	if cmd.verb == "hb" {
+		return u.hub.Heartbeat(cmd.service)
+	}
+	return u.hub.Log(cmd.service, cmd.summary, cmd.severity, u.timeline.Now())
+}
+
+// false if cmd is a repeat of a signed command accepted before
+func (u *UdpListener) checkReplay(cmd *udpCommand, now time.Time) bool {
+	if cmd.signature == "" {
+		return true
+	}
+
+	// a command is refused as too old UDP_MAX_SKEW after it was sent, and
+	// it can't have been sent more than UDP_MAX_SKEW before it arrived
+	for len(u.seenOrder) > 0 && now.Sub(u.seenOrder[0].received) > 2 * UDP_MAX_SKEW {
+		delete(u.seen, u.seenOrder[0].signature)
+		u.seenOrder = u.seenOrder[1:]
+	}
+
+	if u.seen[cmd.signature] {
+		return false
+	}
+	u.seen[cmd.signature] = true
+	u.seenOrder = append(u.seenOrder, udpSeen{cmd.signature, now})
+	return true
+}
+
+func (u *UdpListener) handleDatagram(datagram string, from net.Addr) {
+	severities := u.hub.GetSeverities()
+	now := u.timeline.Now()
+
+	u.mutex.Lock()
+	secret := u.secret
+	u.mutex.Unlock()
+
+	for _, line := range(strings.Split(datagram, "\n")) {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		cmd, err := parseUdpCommand(line, secret, severities, now)
+		if err == nil && !u.checkReplay(cmd, now) {
+			err = &ApiError{"Replayed command"}
+		}
+		if err == nil {
+			err = u.execute(cmd)
+		}
+
+		if err != nil {
+			log.Println("udp "+from.String()+": "+err.String())
+		}
+	}
+}
+
+func (u *UdpListener) Listen(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	u.conn = conn
+	return nil
+}
+
+func (u *UdpListener) Serve() {
+	buffer := make([]byte, UDP_MAX_DATAGRAM)
+	for {
+		count, from, err := u.conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("udp read error: "+err.Error())
+			continue
+		}
+		u.handleDatagram(string(buffer[:count]), from)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestUdpParseHeartbeat(c *C) {
+	cmd, err := parseUdpCommand("hb Alpha", nil, defaultSeverities, time.Unix(1000, 0))
+	c.Assert(err, IsNil)
+	c.Assert(cmd.verb, Equals, "hb")
+	c.Assert(cmd.service, Equals, "Alpha")
+}
+
+func (s *S) TestUdpParseLog(c *C) {
+	cmd, err := parseUdpCommand("log Alpha 3 disk   almost full", nil, defaultSeverities, time.Unix(1000, 0))
+	c.Assert(err, IsNil)
+	c.Assert(cmd.verb, Equals, "log")
+	c.Assert(cmd.service, Equals, "Alpha")
+	c.Assert(cmd.severity, Equals, WARN)
+	c.Assert(cmd.summary, Equals, "disk almost full")
+
+	cmd, err = parseUdpCommand("log Alpha critical db unreachable", nil, defaultSeverities, time.Unix(1000, 0))
+	c.Assert(err, IsNil)
+	c.Assert(cmd.severity, Equals, CRITICAL)
+}
+
+func (s *S) TestUdpParseMalformed(c *C) {
+	_, err := parseUdpCommand("log Alpha 3", nil, defaultSeverities, time.Unix(1000, 0))
+	c.Assert(err, NotNil)
+
+	_, err = parseUdpCommand("log Alpha x summary", nil, defaultSeverities, time.Unix(1000, 0))
+	c.Assert(err, NotNil)
+
+	_, err = parseUdpCommand("ping Alpha", nil, defaultSeverities, time.Unix(1000, 0))
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestUdpParseSigned(c *C) {
+	secret := []byte("sekrit")
+	now := time.Unix(1000, 0)
+
+	cmd, err := parseUdpCommand(signUdpCommand(secret, "990 1 hb Alpha")+" 990 1 hb Alpha", secret, defaultSeverities, now)
+	c.Assert(err, IsNil)
+	c.Assert(cmd.service, Equals, "Alpha")
+	c.Assert(cmd.sent, Equals, time.Unix(990, 0))
+
+	_, err = parseUdpCommand(signUdpCommand(secret, "990 1 hb Alpha")+" 990 1 hb Beta", secret, defaultSeverities, now)
+	c.Assert(err, NotNil)
+
+	// the time and nonce are signed too
+	_, err = parseUdpCommand(signUdpCommand(secret, "990 1 hb Alpha")+" 995 1 hb Alpha", secret, defaultSeverities, now)
+	c.Assert(err, NotNil)
+
+	_, err = parseUdpCommand(signUdpCommand(secret, "990 1 hb Alpha")+" 990 2 hb Alpha", secret, defaultSeverities, now)
+	c.Assert(err, NotNil)
+
+	_, err = parseUdpCommand(signUdpCommand(secret, "900 1 hb Alpha")+" 900 1 hb Alpha", secret, defaultSeverities, now)
+	c.Assert(err, NotNil)
+
+	_, err = parseUdpCommand(signUdpCommand(secret, "990 hb Alpha")+" 990 hb Alpha", secret, defaultSeverities, now)
+	c.Assert(err, NotNil)
+
+	_, err = parseUdpCommand("hb Alpha", secret, defaultSeverities, now)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestUdpReplay(c *C) {
+	u := NewUdpListener(nil, nil, "sekrit")
+	now := time.Unix(1000, 0)
+
+	line := signUdpCommand(u.secret, "1000 1 hb Alpha")+" 1000 1 hb Alpha"
+	cmd, err := parseUdpCommand(line, u.secret, defaultSeverities, now)
+	c.Assert(err, IsNil)
+	c.Assert(u.checkReplay(cmd, now), Equals, true)
+	c.Assert(u.checkReplay(cmd, now), Equals, false)
+
+	// the same command sent again in the same second with a new nonce is
+	// fine
+	again, err := parseUdpCommand(signUdpCommand(u.secret, "1000 2 hb Alpha")+" 1000 2 hb Alpha", u.secret, defaultSeverities, now)
+	c.Assert(err, IsNil)
+	c.Assert(u.checkReplay(again, now), Equals, true)
+
+	// still refused until it would be too old anyway
+	c.Assert(u.checkReplay(cmd, now.Add(2 * UDP_MAX_SKEW)), Equals, false)
+
+	// and the seen signatures are forgotten after that
+	c.Assert(u.checkReplay(&udpCommand{signature: "x", sent: now}, now.Add(2 * UDP_MAX_SKEW + time.Second)), Equals, true)
+	c.Assert(len(u.seen), Equals, 1)
+	c.Assert(len(u.seenOrder), Equals, 1)
+}
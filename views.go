@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
+	)
+
+// The pages are html/template files in resource/views.  Each page may use
+// the "head" and "foot" templates, which are the files of the same name.
+// Templates are parsed once; in dev mode they are parsed again whenever a
+// file in views changes, so the UI can be worked on without restarting.
+
+type viewSet struct {
+	resources fs.FS
+	dev bool
+
+	mutex sync.Mutex
+	templates map[string] *template.Template
+	// the newest modification time in views when templates were parsed
+	parsedAt time.Time
+}
+
+func newViewSet(resources fs.FS, dev bool) *viewSet {
+	return &viewSet{resources: resources, dev: dev, templates: make(map[string] *template.Template)}
+}
+
+// the newest modification time of the files in views.  Embedded files have
+// no modification time, so this is always zero for them.
+func (v *viewSet) lastModified() time.Time {
+	var newest time.Time
+	entries, err := fs.ReadDir(v.resources, "views")
+	if err != nil {
+		return newest
+	}
+	for _, entry := range(entries) {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+func (v *viewSet) lookup(name string) (*template.Template, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.dev {
+		if modified := v.lastModified(); modified.After(v.parsedAt) {
+			if !v.parsedAt.IsZero() {
+				log.Println("views changed, reloading templates")
+			}
+			v.templates = make(map[string] *template.Template)
+			v.parsedAt = modified
+		}
+	}
+
+	if t, exists := v.templates[name]; exists {
+		return t, nil
+	}
+
+	t, err := template.New(name).ParseFS(v.resources, "views/head", "views/foot", "views/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	v.templates[name] = t
+	return t, nil
+}
+
+func (v *viewSet) Render(w io.Writer, name string, data interface{}) error {
+	t, err := v.lookup(name)
+	if err != nil {
+		return err
+	}
+	return t.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (s *S) TestViewsEscape(c *C) {
+	views := newViewSet(resourceFS(""), false)
+
+	service := ServiceSnapshot{Name: "<b>web</b>", Description: "a & b", Link: "javascript:alert(1)", IsUp: true}
+	b := new(bytes.Buffer)
+	err := views.Render(b, "dashboard.tpl", map[string]interface{}{
+		"groups": []*ServiceGroup{&ServiceGroup{Group: "default", Services: []*ServiceSnapshot{&service}}},
+		"summary": &DashboardSummary{},
+		"query": &DashboardQuery{Sort: "name"},
+		"silences": []*SilenceSnapshot{}})
+	c.Assert(err, IsNil)
+
+	html := b.String()
+	c.Assert(strings.Contains(html, "&lt;b&gt;web&lt;/b&gt;"), Equals, true)
+	c.Assert(strings.Contains(html, "/service?name=%3cb%3eweb%3c%2fb%3e"), Equals, true)
+	c.Assert(strings.Contains(html, "a &amp; b"), Equals, true)
+	c.Assert(strings.Contains(html, "javascript:"), Equals, false)
+}
+
+func (s *S) TestViewsParse(c *C) {
+	views := newViewSet(resourceFS(""), false)
+
+	for _, name := range([]string{"dashboard.tpl", "table.tpl", "event.tpl", "incidents.tpl", "incident.tpl", "service.tpl", "graph.tpl", "events.tpl", "login.tpl", "tokens.tpl"}) {
+		_, err := views.lookup(name)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *S) TestViewsReloadInDevMode(c *C) {
+	dir, err := os.MkdirTemp("", "gospoke")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "views"), 0755)
+	write := func(name string, text string, modified time.Time) {
+		filename := filepath.Join(dir, "views", name)
+		os.WriteFile(filename, []byte(text), 0644)
+		os.Chtimes(filename, modified, modified)
+	}
+	write("head", "", time.Unix(1000, 0))
+	write("foot", "", time.Unix(1000, 0))
+	write("page.tpl", "one", time.Unix(1000, 0))
+
+	views := newViewSet(os.DirFS(dir), true)
+	b := new(bytes.Buffer)
+	views.Render(b, "page.tpl", nil)
+	c.Assert(b.String(), Equals, "one")
+
+	write("page.tpl", "two", time.Unix(2000, 0))
+	b.Reset()
+	views.Render(b, "page.tpl", nil)
+	c.Assert(b.String(), Equals, "two")
+}
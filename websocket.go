@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"golang.org/x/net/websocket"
+	)
+
+// WebSocket API for consoles which want to follow the hub.  After
+// connecting, a client sends a subscription (and may send another at any
+// time to change it):
+//
+//   {"type": "subscribe", "services": ["db"], "groups": ["infra"], "min_severity": "warn"}
+//
+// Every field is optional; an empty list matches everything.  min_severity
+// only applies to log entries.  The hub then sends each matching event as
+//
+//   {"type": "log", "service": "db", "group": "infra", "data": {...}}
+//
+// with the same types and data as /events/stream.  Events about no
+// particular service (notifications, silences) pass the service and group
+// filters.  A client which falls behind misses events rather than slowing
+// the hub.
+
+type wsSubscription struct {
+	services map[string] bool
+	groups map[string] bool
+	minSeverity int
+}
+
+type wsRequest struct {
+	Type string `json:"type"`
+	Services []string `json:"services"`
+	Groups []string `json:"groups"`
+	MinSeverity interface{} `json:"min_severity"`
+}
+
+type wsMessage struct {
+	Type string `json:"type"`
+	Service string `json:"service,omitempty"`
+	Group string `json:"group,omitempty"`
+	Data map[string] interface{} `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func newWsSubscription(req *wsRequest, severities *SeverityTable) (*wsSubscription, error) {
+	sub := &wsSubscription{}
+
+	if len(req.Services) > 0 {
+		sub.services = make(map[string] bool)
+		for _, name := range(req.Services) {
+			sub.services[name] = true
+		}
+	}
+
+	if len(req.Groups) > 0 {
+		sub.groups = make(map[string] bool)
+		for _, name := range(req.Groups) {
+			sub.groups[name] = true
+		}
+	}
+
+	if req.MinSeverity != nil {
+		severity, err := severities.ParseValue(req.MinSeverity)
+		if err != nil {
+			return nil, err
+		}
+		sub.minSeverity = severity
+	}
+
+	return sub, nil
+}
+
+func (sub *wsSubscription) Matches(e *HubEvent) bool {
+	if e.Service != "" {
+		if sub.services != nil && !sub.services[e.Service] {
+			return false
+		}
+		if sub.groups != nil && !sub.groups[e.Group] {
+			return false
+		}
+	}
+
+	if e.Type == "log" {
+		if severity, ok := e.Data["severity"].(int); ok && severity < sub.minSeverity {
+			return false
+		}
+	}
+
+	return true
+}
+
+type wsHandler struct {
+	hub ThreadSafeServiceHub
+}
+
+func NewWebSocketHandler(hub ThreadSafeServiceHub) http.Handler {
+	h := &wsHandler{hub}
+	return websocket.Server{Handler: h.serve, Handshake: func(_ *websocket.Config, r *http.Request) error { return checkWsOrigin(r) }}
+}
+
+// consoles aren't browsers, so don't insist on an Origin header, but one
+// that is sent must be this host so that another site can't open the socket
+// with a signed in user's cookies
+func checkWsOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return errors.New("bad Origin \""+origin+"\"")
+	}
+	if u.Host != r.Host {
+		return errors.New("cross-site request from \""+origin+"\"")
+	}
+	return nil
+}
+
+func (h *wsHandler) serve(ws *websocket.Conn) {
+	defer ws.Close()
+
+	events := h.hub.Subscribe()
+	defer h.hub.Unsubscribe(events)
+
+	// subscriptions are read on their own goroutine so that events can be
+	// written while we wait for the client
+	subscriptions := make(chan *wsSubscription)
+	replies := make(chan *wsMessage)
+	done := make(chan bool)
+	quit := make(chan bool)
+	defer close(quit)
+
+	go func() {
+		defer close(done)
+		for {
+			var req wsRequest
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				return
+			}
+
+			var sub *wsSubscription
+			var reply *wsMessage
+			if req.Type != "subscribe" {
+				reply = &wsMessage{Type: "error", Error: "unknown request type \""+req.Type+"\""}
+			} else if s, err := newWsSubscription(&req, h.hub.GetSeverities()); err != nil {
+				reply = &wsMessage{Type: "error", Error: err.Error()}
+			} else {
+				sub = s
+			}
+
+			if sub != nil {
+				select {
+				case subscriptions <- sub:
+				case <-quit:
+					return
+				}
+			} else {
+				select {
+				case replies <- reply:
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+
+	// nothing is sent until the client subscribes
+	var sub *wsSubscription
+	for {
+		var msg *wsMessage
+
+		select {
+		case e, open := <-events:
+			if !open {
+				return
+			}
+			if sub == nil || !sub.Matches(e) {
+				continue
+			}
+			msg = &wsMessage{Type: e.Type, Service: e.Service, Group: e.Group, Data: e.Data}
+		case sub = <-subscriptions:
+			msg = &wsMessage{Type: "subscribed"}
+		case msg = <-replies:
+		case <-done:
+			return
+		}
+
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			log.Println("websocket "+ws.Request().RemoteAddr+": "+err.Error())
+			return
+		}
+	}
+}
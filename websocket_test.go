@@ -0,0 +1,40 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"net/http/httptest"
+)
+
+func (s *S) TestWebSocketSubscription(c *C) {
+	sub, err := newWsSubscription(&wsRequest{Type: "subscribe", Groups: []string{"infra"}, MinSeverity: "error"}, defaultSeverities)
+	c.Assert(err, IsNil)
+
+	c.Assert(sub.Matches(&HubEvent{Type: "status", Service: "db", Group: "infra"}), Equals, true)
+	c.Assert(sub.Matches(&HubEvent{Type: "status", Service: "web", Group: "frontend"}), Equals, false)
+	c.Assert(sub.Matches(&HubEvent{Type: "log", Service: "db", Group: "infra", Data: map[string] interface{}{"severity": WARN}}), Equals, false)
+	c.Assert(sub.Matches(&HubEvent{Type: "log", Service: "db", Group: "infra", Data: map[string] interface{}{"severity": CRITICAL}}), Equals, true)
+	// events about no service aren't filtered by service or group
+	c.Assert(sub.Matches(&HubEvent{Type: "silence"}), Equals, true)
+
+	sub, err = newWsSubscription(&wsRequest{Type: "subscribe", Services: []string{"db"}}, defaultSeverities)
+	c.Assert(err, IsNil)
+	c.Assert(sub.Matches(&HubEvent{Type: "heartbeat", Service: "db"}), Equals, true)
+	c.Assert(sub.Matches(&HubEvent{Type: "heartbeat", Service: "web"}), Equals, false)
+
+	_, err = newWsSubscription(&wsRequest{Type: "subscribe", MinSeverity: "loud"}, defaultSeverities)
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestWebSocketOrigin(c *C) {
+	r := httptest.NewRequest("GET", "http://hub.example.com:8080/ws", nil)
+	c.Assert(checkWsOrigin(r), IsNil)
+
+	r.Header.Set("Origin", "http://hub.example.com:8080")
+	c.Assert(checkWsOrigin(r), IsNil)
+
+	r.Header.Set("Origin", "https://evil.example.com")
+	c.Assert(checkWsOrigin(r), NotNil)
+
+	r.Header.Set("Origin", "null")
+	c.Assert(checkWsOrigin(r), NotNil)
+}